@@ -1,8 +1,100 @@
 package factory
 
+import "strings"
+
 type Prototype struct {
-	TableName string
-	Outline   string
-	BuildOnly bool
-	Name      *string
+	TableName           string
+	Outline             string
+	OutlineMap          map[string]interface{}
+	BuildOnly           bool
+	Name                *string
+	PrimaryKey          string
+	ReadOnlyColumns     []string
+	AutoIncrementColumn string
+	RequiredOverrides   []string
+	StrictColumns       bool
+	ColumnMap           map[string]string
+	Fragment            bool
+	ColumnOrder         []string
+	ConflictColumns     []string
+	ConflictDoNothing   bool
+	Returning           []string
+	Defaults            map[string]interface{}
+	ColumnCasts         map[string]string
+	DirtyComparator     map[string]func(old, new interface{}) bool
+	ReadOnly            bool
+
+	foldedColumns map[string]string
+}
+
+func (p *Prototype) column(key string) string {
+	if p == nil {
+		return key
+	}
+	if column, ok := p.ColumnMap[key]; ok {
+		return column
+	}
+
+	return key
+}
+
+func (p *Prototype) toColumns(contents map[string]interface{}) map[string]interface{} {
+	if p == nil || len(p.ColumnMap) == 0 {
+		return contents
+	}
+
+	row := make(map[string]interface{}, len(contents))
+	for k, v := range contents {
+		row[p.column(k)] = v
+	}
+
+	return row
+}
+
+func (p *Prototype) fromColumns(row map[string]interface{}) map[string]interface{} {
+	if p == nil || (len(p.ColumnMap) == 0 && len(p.foldedColumns) == 0) {
+		return row
+	}
+
+	keys := make(map[string]string, len(p.ColumnMap))
+	for key, column := range p.ColumnMap {
+		keys[column] = key
+	}
+
+	contents := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		if column, ok := p.foldedColumns[k]; ok {
+			k = column
+		}
+		if key, ok := keys[k]; ok {
+			k = key
+		}
+		contents[k] = v
+	}
+
+	return contents
+}
+
+// postgres folds unquoted identifiers to lower case, so mixed case columns
+// come back in lower case and are mapped back to the casing of the prototype
+func (p *Prototype) foldColumns() error {
+	keys, err := outlineColumns(p.Outline)
+	if err != nil {
+		return err
+	}
+	for key := range p.Defaults {
+		keys = append(keys, key)
+	}
+	keys = append(keys, p.PrimaryKey, p.AutoIncrementColumn)
+	keys = append(keys, p.Returning...)
+
+	p.foldedColumns = make(map[string]string)
+	for _, key := range keys {
+		column := p.column(key)
+		if folded := strings.ToLower(column); folded != column {
+			p.foldedColumns[folded] = column
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,38 @@
+package factory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const aggregateAlias = "aggregate"
+
+var aggregateRegex = regexp.MustCompile(`(?i)^(count|sum|min|max|avg)\((\*|(distinct\s+)?[a-z_][a-z0-9_.]*)\)$`)
+
+func (b *Builder) Aggregate(table, expr, query string) (interface{}, error) {
+	if !aggregateRegex.MatchString(expr) {
+		return nil, fmt.Errorf("could not aggregate %s from %s: unsupported expression", expr, table)
+	}
+
+	var where map[string]interface{}
+	if err := json.Unmarshal([]byte(query), &where); err != nil {
+		return nil, fmt.Errorf("could not aggregate %s from %s: json error: %s: %s", expr, table, err.Error(), query)
+	}
+
+	q, ok := b.persister.(columnQuerier)
+	if !ok {
+		return nil, fmt.Errorf("could not aggregate %s from %s: persister %T does not support aggregates", expr, table, b.persister)
+	}
+
+	rows, err := q.queryColumns(context.Background(), table, []string{expr + " AS " + aggregateAlias}, where)
+	if err != nil {
+		return nil, fmt.Errorf("could not aggregate %s from %s: %w", expr, table, err)
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("could not aggregate %s from %s: expected 1 row, found %d", expr, table, len(rows))
+	}
+
+	return rows[0][aggregateAlias], nil
+}
@@ -0,0 +1,145 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type BatchInserter interface {
+	InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) ([]map[string]interface{}, error)
+}
+
+// auto increment columns can only be filled in after a batch if the rows are returned
+func (i *Instance) batchable() bool {
+	if i.persisted {
+		return false
+	}
+	if i.prototype == nil {
+		return true
+	}
+	if i.prototype.ReadOnly {
+		return false
+	}
+
+	return (i.prototype.AutoIncrementColumn == "" || len(i.prototype.Returning) > 0) && len(i.prototype.ConflictColumns) == 0 && !i.prototype.ConflictDoNothing
+}
+
+// insertBatches splits instances into groups that share the same columns, as
+// instances built with Unset or With can differ and a multi row insert needs
+// every row to have the same column list
+func insertBatches(ctx context.Context, batcher BatchInserter, instances []*Instance) []error {
+	var (
+		signatures []string
+		errs       []error
+		groups     = make(map[string][]*Instance)
+		rows       = make(map[*Instance]map[string]interface{}, len(instances))
+	)
+	for _, instance := range instances {
+		instance.resolveAssociations()
+		row, err := instance.insertContents()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error saving %s into %s: could not persist: %w", instance.displayName(), instance.tableName, err))
+			continue
+		}
+		rows[instance] = row
+
+		signature := strings.Join(sortedColumns(row), ",")
+		if _, ok := groups[signature]; !ok {
+			signatures = append(signatures, signature)
+		}
+		groups[signature] = append(groups[signature], instance)
+	}
+
+	for _, signature := range signatures {
+		group := groups[signature]
+		batchRows := make([]map[string]interface{}, len(group))
+		names := make([]string, len(group))
+		for idx, instance := range group {
+			batchRows[idx] = rows[instance]
+			names[idx] = instance.name
+		}
+
+		proto := group[0].prototype
+		batchCtx := withReturning(withColumnCasts(withColumnOrder(ctx, proto), proto), proto)
+		matchColumn := batchMatchColumn(proto, batchRows)
+		if matchColumn != "" {
+			batchCtx = withReturningColumn(batchCtx, matchColumn)
+		}
+		returned, err := batcher.InsertBatch(batchCtx, group[0].tableName, batchRows)
+		if err == nil && matchColumn != "" && len(returned) == len(batchRows) {
+			returned, err = matchReturned(batchRows, returned, matchColumn)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error saving %s into %s: could not persist: %w", strings.Join(names, ", "), group[0].tableName, err))
+			continue
+		}
+
+		for idx, instance := range group {
+			var r map[string]interface{}
+			if idx < len(returned) {
+				r = returned[idx]
+			}
+			instance.markPersisted(r)
+			if err := instance.runOnSave(); err != nil {
+				errs = append(errs, fmt.Errorf("error saving %s into %s: %w", instance.displayName(), instance.tableName, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// postgres doesn't promise to return the rows of a multi row insert in the order
+// of its values, so they are matched on the primary key when every row has one
+func batchMatchColumn(proto *Prototype, rows []map[string]interface{}) string {
+	if proto == nil || proto.PrimaryKey == "" {
+		return ""
+	}
+
+	column := proto.column(proto.PrimaryKey)
+	seen := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		switch value := row[column].(type) {
+		case nil, defaultValue, rawValue:
+			return ""
+		default:
+			key := fmt.Sprintf("%v", value)
+			if seen[key] {
+				return ""
+			}
+			seen[key] = true
+		}
+	}
+
+	return column
+}
+
+func matchReturned(rows, returned []map[string]interface{}, column string) ([]map[string]interface{}, error) {
+	byKey := make(map[string]map[string]interface{}, len(returned))
+	for _, r := range returned {
+		byKey[fmt.Sprintf("%v", r[column])] = r
+	}
+
+	matched := make([]map[string]interface{}, len(rows))
+	for idx, row := range rows {
+		r, ok := byKey[fmt.Sprintf("%v", row[column])]
+		if !ok {
+			return nil, fmt.Errorf("no row returned with %s %v", column, row[column])
+		}
+		matched[idx] = r
+	}
+
+	return matched, nil
+}
+
+func sortedColumns(row map[string]interface{}) []string {
+	columns := make([]string, 0, len(row))
+	for k := range row {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+
+	return columns
+}
@@ -2,106 +2,509 @@ package factory
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/gofrs/uuid"
 )
 
 const (
-	uuidVar = "uuid"
+	uuidVar              = "uuid"
+	seqTimeVar           = "seqtime"
+	seqTimeStep          = time.Second
+	optionalVar          = "optional"
+	skippedVar           = "__factory_skipped__"
+	varNamePattern       = `[a-zA-Z0-9_]+(?:\.[a-zA-Z0-9_]+)*`
+	defaultFindChunkSize = 1000
 )
 
-var varReplacementRegex = regexp.MustCompile(`\{\{([a-zA-z0-9]+)\}\}`)
+var (
+	varReplacementRegex = regexp.MustCompile(`\{\{(` + varNamePattern + `)\}\}`)
+	varNameRegex        = regexp.MustCompile(`^` + varNamePattern + `$`)
+	defaultTimeBase     = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
 
 type (
-	PersistFunc func(ctx context.Context, sqlStatement string, args ...any) error
-	QueryFunc   func(ctx context.Context, sqlStatement string, args ...any) (string, error)
+	PersistFunc       func(ctx context.Context, sqlStatement string, args ...any) error
+	PersistResultFunc func(ctx context.Context, sqlStatement string, args ...any) (sql.Result, error)
+	QueryFunc         func(ctx context.Context, sqlStatement string, args ...any) (string, error)
+	QueryRowsFunc     func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error)
 )
 
 type Builder struct {
 	prototypes        map[string]Prototype
 	instances         []*Instance
-	setterFuncs       map[string]func() string
-	persistFunc       PersistFunc
-	queryFunc         QueryFunc
-	placeholderFormat squirrel.PlaceholderFormat
+	setterFuncs       map[string]func(instanceName string) (string, error)
+	tableSetterFuncs  map[string]map[string]func() string
+	persister         Persister
+	tableNameFunc     func(name string) string
+	defaultIDColumn   string
+	savepoints        map[string]savepoint
+	structTag         string
+	batchInserts      bool
+	rowTransform      func(table string, row map[string]interface{}) (map[string]interface{}, error)
+	findChunkSize     int
+	cacheFinds        bool
+	findCache         map[string]map[string][]*Instance
+	nilStrategy       NilStrategy
+	beforePersist     func(table string, contents map[string]interface{}) error
+	foldIdentifiers   bool
+	schema            string
+	emptyStringAsNull bool
+	lazyBuilds        bool
+	autoFlush         int
+	displayColumn     string
+	validating        bool
 }
 
 type BuilderConfig struct {
 	PersistFunc
+	PersistResultFunc
 	QueryFunc
+	QueryRowsFunc
 	squirrel.PlaceholderFormat
+	Persister
+	TableNameFunc         func(name string) string
+	DefaultIDColumn       string
+	StructTag             string
+	BatchInserts          bool
+	RowTransform          func(table string, row map[string]interface{}) (map[string]interface{}, error)
+	FindChunkSize         int
+	DisableDefaultSetters bool
+	CacheFinds            bool
+	NilStrategy           NilStrategy
+	BeforePersist         func(table string, contents map[string]interface{}) error
+	TimeBase              time.Time
+	SQLRewrite            func(op OpKind, sql string) string
+	FoldIdentifiers       bool
+	Schema                string
+	EmptyStringAsNull     bool
+	LazyBuilds            bool
+	DefaultFindOrderBy    string
+	AutoFlushThreshold    int
+	DisplayNameColumn     string
 }
 
 func NewBuilder(config *BuilderConfig) *Builder {
+	persister := config.Persister
+	if persister == nil {
+		sqlPersister := NewSQLPersister(config.PersistFunc, config.QueryFunc, config.PlaceholderFormat)
+		sqlPersister.persistResultFunc = config.PersistResultFunc
+		sqlPersister.queryRowsFunc = config.QueryRowsFunc
+		sqlPersister.sqlRewrite = config.SQLRewrite
+		sqlPersister.schema = config.Schema
+		sqlPersister.orderBy = config.DefaultFindOrderBy
+		persister = sqlPersister
+	}
+
+	tableNameFunc := config.TableNameFunc
+	if tableNameFunc == nil {
+		tableNameFunc = Pluralize
+	}
+
+	defaultIDColumn := config.DefaultIDColumn
+	if defaultIDColumn == "" {
+		defaultIDColumn = "id"
+	}
+
+	findChunkSize := config.FindChunkSize
+	if findChunkSize <= 0 {
+		findChunkSize = defaultFindChunkSize
+	}
+
+	structTag := config.StructTag
+	if structTag == "" {
+		structTag = "db"
+	}
+
+	timeBase := config.TimeBase
+	if timeBase.IsZero() {
+		timeBase = defaultTimeBase
+	}
+
+	setterFuncs := make(map[string]func(instanceName string) (string, error))
+	if !config.DisableDefaultSetters {
+		setterFuncs[uuidVar] = func(string) (string, error) {
+			return uuid.Must(uuid.NewV4()).String(), nil
+		}
+
+		next := timeBase
+		setterFuncs[seqTimeVar] = func(string) (string, error) {
+			value := next.Format(time.RFC3339Nano)
+			next = next.Add(seqTimeStep)
+			return value, nil
+		}
+	}
+
 	return &Builder{
-		persistFunc:       config.PersistFunc,
-		queryFunc:         config.QueryFunc,
-		placeholderFormat: config.PlaceholderFormat,
+		structTag:         structTag,
+		batchInserts:      config.BatchInserts,
+		rowTransform:      config.RowTransform,
+		findChunkSize:     findChunkSize,
+		cacheFinds:        config.CacheFinds,
+		nilStrategy:       config.NilStrategy,
+		beforePersist:     config.BeforePersist,
+		foldIdentifiers:   config.FoldIdentifiers,
+		schema:            config.Schema,
+		emptyStringAsNull: config.EmptyStringAsNull,
+		lazyBuilds:        config.LazyBuilds,
+		autoFlush:         config.AutoFlushThreshold,
+		displayColumn:     config.DisplayNameColumn,
+		findCache:         make(map[string]map[string][]*Instance),
+		persister:         persister,
+		tableNameFunc:     tableNameFunc,
+		defaultIDColumn:   defaultIDColumn,
+		savepoints:        make(map[string]savepoint),
 		prototypes:        make(map[string]Prototype),
 		instances:         make([]*Instance, 0),
-		setterFuncs: map[string]func() string{
-			uuidVar: func() string {
-				return uuid.Must(uuid.NewV4()).String()
-			},
-		},
+		setterFuncs:       setterFuncs,
+		tableSetterFuncs:  make(map[string]map[string]func() string),
 	}
 }
 
+func NewTxBuilder(tx *sql.Tx, format squirrel.PlaceholderFormat) *Builder {
+	return NewBuilder(&BuilderConfig{
+		PersistResultFunc: func(ctx context.Context, sqlStatement string, args ...any) (sql.Result, error) {
+			return tx.ExecContext(ctx, sqlStatement, args...)
+		},
+		QueryRowsFunc:     NewTxQueryRowsFunc(tx),
+		PlaceholderFormat: format,
+	})
+}
+
 func (b *Builder) LoadPrototype(prototype Prototype) {
+	err := b.LoadPrototypeE(prototype)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+func (b *Builder) LoadPrototypeE(prototype Prototype) error {
+	if prototype.TableName == "" {
+		if prototype.Name == nil {
+			return fmt.Errorf("prototype must have a table name or a name")
+		}
+		if !prototype.Fragment {
+			prototype.TableName = b.tableNameFunc(*prototype.Name)
+		}
+	}
+
 	name := prototype.Name
 	if name == nil {
 		name = &prototype.TableName
 	}
 
+	if prototype.OutlineMap != nil {
+		if prototype.Outline != "" {
+			return fmt.Errorf("prototype %s cannot have both an Outline and an OutlineMap", *name)
+		}
+		outline, err := json.Marshal(prototype.OutlineMap)
+		if err != nil {
+			return fmt.Errorf("could not encode prototype %s outline map: %w", *name, err)
+		}
+		prototype.Outline = string(outline)
+	}
+
+	if kind := outlineKind(prototype.Outline); kind != "object" {
+		return fmt.Errorf("prototype %s outline must be a JSON object, got %s", *name, kind)
+	}
+
+	for key, cast := range prototype.ColumnCasts {
+		if !castRegex.MatchString(cast) {
+			return fmt.Errorf("prototype %s has an invalid cast %q for %s", *name, cast, key)
+		}
+	}
+
+	if b.foldIdentifiers {
+		if err := prototype.foldColumns(); err != nil {
+			return fmt.Errorf("could not read prototype %s columns: %w", *name, err)
+		}
+	}
+
 	b.prototypes[*name] = prototype
+	return nil
 }
 
 func (b *Builder) LoadSetterFunc(name string, f func() string) {
+	b.LoadNamedSetterFunc(name, func(string) string {
+		return f()
+	})
+}
+
+func (b *Builder) LoadNamedSetterFunc(name string, f func(instanceName string) string) {
+	b.loadSetterFunc(name, func(instanceName string) (string, error) {
+		return f(instanceName), nil
+	})
+}
+
+func (b *Builder) LoadFallibleSetterFunc(name string, f func() (string, error)) {
+	b.loadSetterFunc(name, func(string) (string, error) {
+		return f()
+	})
+}
+
+func (b *Builder) loadSetterFunc(name string, f func(instanceName string) (string, error)) {
+	if !varNameRegex.MatchString(name) {
+		panic(fmt.Sprintf("invalid setter function name %s", name))
+	}
 	b.setterFuncs[name] = f
 }
 
+func (b *Builder) LoadTableSetterFunc(table, name string, f func() string) {
+	if !varNameRegex.MatchString(name) {
+		panic(fmt.Sprintf("invalid setter function name %s", name))
+	}
+	if b.tableSetterFuncs[table] == nil {
+		b.tableSetterFuncs[table] = make(map[string]func() string)
+	}
+	b.tableSetterFuncs[table][name] = f
+}
+
+func (b *Builder) LoadPoolSetter(name string, values []string) {
+	if len(values) == 0 {
+		panic(fmt.Sprintf("pool setter %s needs at least one value", name))
+	}
+
+	pool := append([]string(nil), values...)
+	next := 0
+	b.LoadSetterFunc(name, func() string {
+		value := pool[next%len(pool)]
+		next++
+		return value
+	})
+}
+
 func (b *Builder) Build(prototypeName string, instanceName ...string) *Instance {
+	instance, err := b.build(prototypeName, buildOptions{}, instanceName...)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return instance
+}
+
+func (b *Builder) BuildE(prototypeName string, overrides map[string]interface{}, instanceName ...string) (*Instance, error) {
+	return b.build(prototypeName, buildOptions{overrides: overrides}, instanceName...)
+}
+
+func (b *Builder) BuildSkip(prototypeName string, skipVars []string, instanceName ...string) *Instance {
+	skip := make(map[string]bool, len(skipVars))
+	for _, v := range skipVars {
+		skip[v] = true
+	}
+
+	instance, err := b.build(prototypeName, buildOptions{skip: skip}, instanceName...)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return instance
+}
+
+func (b *Builder) BuildDetached(prototypeName string, instanceName ...string) *Instance {
+	instance, err := b.build(prototypeName, buildOptions{detached: true}, instanceName...)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return instance
+}
+
+func (b *Builder) BuildManyFunc(prototypeName string, count int, fn func(i int, inst *Instance), instanceName ...string) []*Instance {
+	instances := make([]*Instance, 0, count)
+	for i := 0; i < count; i++ {
+		instance, err := b.build(prototypeName, buildOptions{}, instanceName...)
+		if err != nil {
+			panic(err.Error())
+		}
+		if fn != nil {
+			fn(i, instance)
+		}
+		instances = append(instances, instance)
+	}
+
+	return instances
+}
+
+type buildOptions struct {
+	skip      map[string]bool
+	overrides map[string]interface{}
+	detached  bool
+}
+
+func (b *Builder) build(prototypeName string, opts buildOptions, instanceName ...string) (*Instance, error) {
 	proto, ok := b.prototypes[prototypeName]
 	if !ok {
-		panic(fmt.Sprintf("could not build instance of %s: no prototype found", prototypeName))
+		return nil, fmt.Errorf("could not build instance of %s: no prototype found", prototypeName)
 	}
+	if proto.TableName == "" {
+		return nil, fmt.Errorf("could not build instance of %s: fragment has no table name", prototypeName)
+	}
+
+	contents, err := b.outlineContents(prototypeName, instanceNameOr(prototypeName, instanceName), proto, opts.skip)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range opts.overrides {
+		contents[k] = v
+	}
+
+	return b.newInstance(prototypeName, proto, contents, opts, instanceName...)
+}
 
+func (b *Builder) outlineContents(prototypeName, instanceName string, proto Prototype, skip map[string]bool) (map[string]interface{}, error) {
 	outline := proto.Outline
 
 	vars := varReplacementRegex.FindAllStringSubmatch(outline, -1)
+	replaced := make(map[string]bool, len(vars))
 	for _, v := range vars {
+		// every occurrence is replaced at once, so setters are called once per name
+		if replaced[v[0]] {
+			continue
+		}
+		replaced[v[0]] = true
+
+		if v[1] == optionalVar || skip[v[1]] {
+			outline = strings.ReplaceAll(outline, v[0], skippedVar)
+			continue
+		}
+		if f, ok := b.tableSetterFuncs[proto.TableName][v[1]]; ok {
+			outline = strings.ReplaceAll(outline, v[0], f())
+			continue
+		}
 		f, ok := b.setterFuncs[v[1]]
 		if !ok {
-			panic(fmt.Sprintf("could not build instance of %s: no setter function called %s found", prototypeName, v[1]))
+			return nil, fmt.Errorf("could not build instance of %s: no setter function called %s found", prototypeName, v[1])
 		}
-		outline = strings.ReplaceAll(outline, v[0], f())
+		value, err := f(instanceName)
+		if err != nil {
+			return nil, fmt.Errorf("could not build instance of %s: setter %s failed: %w", prototypeName, v[1], err)
+		}
+		outline = strings.ReplaceAll(outline, v[0], value)
 	}
 
 	var contents map[string]interface{}
 	err := json.Unmarshal([]byte(outline), &contents)
 	if err != nil {
-		panic(fmt.Sprintf("could not build instance of %s %s: json error: %s", prototypeName, outline, err.Error()))
+		return nil, fmt.Errorf("could not build instance of %s %s: json error: %s", prototypeName, outline, err.Error())
 	}
+	removeSkipped(contents)
 
-	name := prototypeName
-	if len(instanceName) > 0 {
-		name = instanceName[0]
+	for k, v := range proto.Defaults {
+		contents[k] = copyValue(v)
+	}
+
+	return contents, nil
+}
+
+// nested defaults are copied so instances don't share and change them
+func copyValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for k, nested := range value {
+			copied[k] = copyValue(nested)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for idx, nested := range value {
+			copied[idx] = copyValue(nested)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+func (b *Builder) newInstance(prototypeName string, proto Prototype, contents map[string]interface{}, opts buildOptions, instanceName ...string) (*Instance, error) {
+	var missing []string
+	for _, column := range proto.RequiredOverrides {
+		if _, ok := contents[column]; !ok {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("could not build instance of %s: missing required overrides %s", prototypeName, strings.Join(missing, ", "))
 	}
 
 	instance := &Instance{
-		name:        name,
+		name:        instanceNameOr(prototypeName, instanceName),
 		baseBuilder: b,
 		contents:    contents,
-		tableName:   prototypeName,
+		tableName:   proto.TableName,
 		buildOnly:   proto.BuildOnly,
+		prototype:   &proto,
+		lazy:        b.lazyBuilds,
+	}
+	if !opts.detached {
+		if err := b.flushPending(); err != nil {
+			return nil, fmt.Errorf("could not build instance of %s: %w", prototypeName, err)
+		}
+		b.instances = append(b.instances, instance)
+	}
+	return instance, nil
+}
+
+// flushPending saves the pending inserts once there are AutoFlushThreshold of them and
+// drops the saved instances from the builder. it runs before the next instance is added,
+// so the latest built instance can still be changed before it is flushed
+func (b *Builder) flushPending() error {
+	if b.autoFlush <= 0 {
+		return nil
+	}
+
+	pending := make(map[*Instance]bool)
+	for _, instance := range b.instances {
+		if instance.saveAction() == saveInsert {
+			pending[instance] = true
+		}
+	}
+	if len(pending) < b.autoFlush {
+		return nil
+	}
+
+	if err := b.SaveE(); err != nil {
+		return fmt.Errorf("could not auto flush: %w", err)
+	}
+
+	kept := make([]*Instance, 0, len(b.instances)-len(pending))
+	for _, instance := range b.instances {
+		if !pending[instance] || !instance.persisted {
+			kept = append(kept, instance)
+		}
+	}
+	b.instances = kept
+
+	return nil
+}
+
+func instanceNameOr(defaultName string, instanceName []string) string {
+	if len(instanceName) > 0 {
+		return instanceName[0]
+	}
+
+	return defaultName
+}
+
+func removeSkipped(contents map[string]interface{}) {
+	for k, v := range contents {
+		switch value := v.(type) {
+		case string:
+			if strings.Contains(value, skippedVar) {
+				delete(contents, k)
+			}
+		case map[string]interface{}:
+			removeSkipped(value)
+		}
 	}
-	b.instances = append(b.instances, instance)
-	return instance
 }
 
 func (b *Builder) Instance(name string, index ...int) *Instance {
@@ -132,53 +535,343 @@ func (b *Builder) Instance(name string, index ...int) *Instance {
 }
 
 func (b *Builder) Save() {
-	for _, instance := range b.instances {
-		name := instance.name
-		if instance.buildOnly {
+	err := b.SaveE()
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+func (b *Builder) SaveE() error {
+	instances, err := b.saveOrder()
+	if err != nil {
+		return fmt.Errorf("could not save: %w", err)
+	}
+
+	errs := b.saveInstances(context.Background(), instances, true)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+func (b *Builder) SaveAll() []error {
+	instances, err := b.saveOrder()
+	if err != nil {
+		return []error{fmt.Errorf("could not save: %w", err)}
+	}
+
+	return b.saveInstances(context.Background(), instances, false)
+}
+
+func (b *Builder) SaveWhere(pred func(*Instance) bool) error {
+	instances, err := b.saveOrder()
+	if err != nil {
+		return fmt.Errorf("could not save: %w", err)
+	}
+
+	selected := make([]*Instance, 0, len(instances))
+	for _, instance := range instances {
+		if pred(instance) {
+			selected = append(selected, instance)
+		}
+	}
+
+	errs := b.saveInstances(context.Background(), selected, true)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+func (b *Builder) saveInstances(ctx context.Context, instances []*Instance, stopOnError bool) []error {
+	batcher, canBatch := b.persister.(BatchInserter)
+	canBatch = canBatch && b.batchInserts
+
+	var errs []error
+	for idx := 0; idx < len(instances); idx++ {
+		if stopOnError && len(errs) > 0 {
+			break
+		}
+
+		instance := instances[idx]
+		if instance.saveAction() == saveSkip {
+			continue
+		}
+
+		if canBatch && instance.batchable() {
+			run := []*Instance{instance}
+			end := idx + 1
+			for ; end < len(instances); end++ {
+				next := instances[end]
+				skipped := next.saveAction() == saveSkip
+				if next.tableName != instance.tableName || !(skipped || next.batchable()) {
+					break
+				}
+				if !skipped {
+					run = append(run, next)
+				}
+			}
+			if len(run) > 1 {
+				errs = append(errs, insertBatches(ctx, batcher, run)...)
+				idx = end - 1
+				continue
+			}
+		}
+
+		err := instance.persist(ctx, b.persister)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error saving %s into %s: %w", instance.displayName(), instance.tableName, err))
+		}
+	}
+
+	return errs
+}
+
+func (b *Builder) DependencyOrder() ([]*Instance, error) {
+	return b.saveOrder()
+}
+
+func (b *Builder) saveOrder() ([]*Instance, error) {
+	return dependencyOrder(b.instances)
+}
+
+// dependencyOrder sorts instances after the parents they belong to, which are
+// included even when they aren't part of instances
+func dependencyOrder(instances []*Instance) ([]*Instance, error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	state := make(map[*Instance]int, len(instances))
+	order := make([]*Instance, 0, len(instances))
+
+	var visit func(instance *Instance) error
+	visit = func(instance *Instance) error {
+		switch state[instance] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle detected at %s", instance.displayName())
+		}
+
+		state[instance] = visiting
+		for _, a := range instance.associations {
+			if err := visit(a.parent); err != nil {
+				return err
+			}
+		}
+		state[instance] = visited
+		order = append(order, instance)
+
+		return nil
+	}
+
+	for _, instance := range instances {
+		if err := visit(instance); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+func (b *Builder) Cleanup(ctx context.Context) error {
+	instances, err := b.saveOrder()
+	if err != nil {
+		return fmt.Errorf("could not clean up: %w", err)
+	}
+
+	for idx := len(instances) - 1; idx >= 0; idx-- {
+		instance := instances[idx]
+		if !instance.created {
 			continue
 		}
-		err := instance.persist(b.persistFunc, b.placeholderFormat)
+		err := instance.remove(ctx, b.persister)
 		if err != nil {
-			panic(fmt.Sprintf("error saving %s: %s", name, err.Error()))
+			return fmt.Errorf("error cleaning up %s: %w", instance.displayName(), err)
+		}
+	}
+
+	return nil
+}
+
+func (b *Builder) ForgetUnpersisted() {
+	persisted := make([]*Instance, 0, len(b.instances))
+	for _, instance := range b.instances {
+		if instance.persisted {
+			persisted = append(persisted, instance)
 		}
 	}
+	b.instances = persisted
 }
 
 func (b *Builder) Find(table, query string, instanceName ...string) []*Instance {
+	instances, err := b.find(table, query, instanceName...)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return instances
+}
+
+func (b *Builder) FindAll(queries map[string]string, names map[string]string) (map[string][]*Instance, error) {
+	tables := make([]string, 0, len(queries))
+	for table := range queries {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	// nothing is registered unless every query succeeded
+	found := make(map[string][]*Instance, len(queries))
+	cached := make(map[string]bool, len(queries))
+	for _, table := range tables {
+		instances, ok, err := b.queryFind(table, queries[table], findName(names, table)...)
+		if err != nil {
+			return nil, fmt.Errorf("could not find all from %s: %w", table, err)
+		}
+		found[table] = instances
+		cached[table] = ok
+	}
+
+	for _, table := range tables {
+		if !cached[table] {
+			b.instances = append(b.instances, found[table]...)
+			b.cacheFind(table, queries[table], found[table], findName(names, table)...)
+		}
+	}
+
+	return found, nil
+}
+
+func findName(names map[string]string, table string) []string {
+	if name, ok := names[table]; ok {
+		return []string{name}
+	}
+
+	return nil
+}
+
+func (b *Builder) find(table, query string, instanceName ...string) ([]*Instance, error) {
+	instances, cached, err := b.queryFind(table, query, instanceName...)
+	if err != nil || cached {
+		return instances, err
+	}
+
+	b.instances = append(b.instances, instances...)
+	b.cacheFind(table, query, instances, instanceName...)
+	return instances, nil
+}
+
+// queryFind returns the instances of a find without registering them, and whether
+// they came from the cache, meaning they were registered before
+func (b *Builder) queryFind(table, query string, instanceName ...string) ([]*Instance, bool, error) {
+	if instances, ok := b.cachedFind(table, query, instanceName...); ok {
+		return instances, true, nil
+	}
+
 	var queryMap map[string]interface{}
 	err := json.Unmarshal([]byte(query), &queryMap)
 	if err != nil {
-		panic(fmt.Sprintf("could not build query: json error: %s: %s", err.Error(), query))
+		return nil, false, fmt.Errorf("could not build query: json error: %s: %s", err.Error(), query)
+	}
+
+	instances, err := b.query(table, queryMap, instanceName...)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not query %s from %s: %s", query, table, err.Error())
 	}
 
-	selectBuilder := squirrel.Select("*").From(table)
+	return instances, false, nil
+}
 
-	for key, value := range queryMap {
-		selectBuilder = selectBuilder.Where(squirrel.Eq{key: value})
+func (b *Builder) FindInto(existing *Instance, query string) error {
+	var queryMap map[string]interface{}
+	if err := json.Unmarshal([]byte(query), &queryMap); err != nil {
+		return fmt.Errorf("could not find %s into %s: json error: %s", query, existing.name, err.Error())
 	}
-	selectBuilder = selectBuilder.PlaceholderFormat(b.placeholderFormat)
 
-	sql, args, err := selectBuilder.ToSql()
+	found, err := b.query(existing.tableName, queryMap, existing.name)
 	if err != nil {
-		panic(fmt.Sprintf("could not build sql: %s", err.Error()))
+		return fmt.Errorf("could not find %s into %s: %w", query, existing.name, err)
+	}
+	if len(found) != 1 {
+		return fmt.Errorf("could not find %s into %s: expected 1 row, found %d", query, existing.name, len(found))
 	}
 
-	result, err := b.queryFunc(context.Background(), sql, args...)
-	if err != nil {
-		panic(fmt.Sprintf("could not query %s from %s: %s", query, table, err.Error()))
+	existing.contents = found[0].contents
+	existing.persistedContents = found[0].persistedContents
+	existing.persisted = true
+	existing.buildOnly = false
+
+	return nil
+}
+
+func (b *Builder) FindByIDs(table string, ids []interface{}, instanceName ...string) ([]*Instance, error) {
+	proto := b.prototypeForTable(table)
+	// the primary key is an outline key, the query needs its column
+	idColumn := b.idColumn(proto)
+	column := proto.column(idColumn)
+
+	var found []*Instance
+	for start := 0; start < len(ids); start += b.findChunkSize {
+		end := start + b.findChunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		chunk, err := b.query(table, map[string]interface{}{column: ids[start:end]}, instanceName...)
+		if err != nil {
+			return nil, fmt.Errorf("could not query %s by %s: %w", table, column, err)
+		}
+		found = append(found, chunk...)
+	}
+
+	byID := make(map[string]*Instance, len(found))
+	for _, instance := range found {
+		byID[fmt.Sprintf("%v", instance.contents[idColumn])] = instance
+	}
+
+	instances := make([]*Instance, 0, len(ids))
+	for _, id := range ids {
+		instance, ok := byID[fmt.Sprintf("%v", id)]
+		if !ok {
+			return nil, fmt.Errorf("could not find %s with %s %v", table, idColumn, id)
+		}
+		instances = append(instances, instance)
 	}
 
-	var contents []map[string]interface{}
-	err = json.Unmarshal([]byte(result), &contents)
+	b.instances = append(b.instances, instances...)
+	return instances, nil
+}
+
+func (b *Builder) query(table string, where map[string]interface{}, instanceName ...string) ([]*Instance, error) {
+	contents, err := b.persister.Query(context.Background(), table, where)
 	if err != nil {
-		panic(fmt.Sprintf("could not unmarshal query result %s: %s", result, err.Error()))
+		return nil, err
 	}
+
+	return b.instancesFromRows(table, contents, instanceName...)
+}
+
+func (b *Builder) instancesFromRows(table string, contents []map[string]interface{}, instanceName ...string) ([]*Instance, error) {
+	proto := b.prototypeForTable(table)
+
 	instances := make([]*Instance, 0)
 	name := table
 	if len(instanceName) > 0 {
 		name = instanceName[0]
 	}
 	for _, c := range contents {
+		if b.rowTransform != nil {
+			var err error
+			c, err = b.rowTransform(table, c)
+			if err != nil {
+				return nil, fmt.Errorf("could not transform row: %w", err)
+			}
+		}
+		c = proto.fromColumns(c)
 		instances = append(instances, &Instance{
 			name:              name,
 			baseBuilder:       b,
@@ -186,10 +879,108 @@ func (b *Builder) Find(table, query string, instanceName ...string) []*Instance
 			contents:          c,
 			tableName:         table,
 			persisted:         true,
-			buildOnly:         true,
+			prototype:         proto,
 		})
 	}
 
-	b.instances = append(b.instances, instances...)
-	return instances
+	return instances, nil
+}
+
+func (b *Builder) Adopt(table string, row map[string]interface{}, instanceName ...string) *Instance {
+	proto := b.prototypeForTable(table)
+
+	contents := make(map[string]interface{}, len(row))
+	for k, v := range proto.fromColumns(row) {
+		contents[k] = v
+	}
+
+	instance := &Instance{
+		name:              instanceNameOr(table, instanceName),
+		baseBuilder:       b,
+		persistedContents: contents,
+		contents:          contents,
+		tableName:         table,
+		persisted:         true,
+		prototype:         proto,
+	}
+	b.instances = append(b.instances, instance)
+	return instance
+}
+
+func (b *Builder) idColumn(proto *Prototype) string {
+	if proto != nil && proto.PrimaryKey != "" {
+		return proto.PrimaryKey
+	}
+
+	return b.defaultIDColumn
+}
+
+func (b *Builder) prototypeForTable(table string) *Prototype {
+	name, ok := b.prototypeNameForTable(table)
+	if !ok {
+		return nil
+	}
+
+	proto := b.prototypes[name]
+	return &proto
+}
+
+func (b *Builder) prototypeNameForTable(table string) (string, bool) {
+	if proto, ok := b.prototypes[table]; ok && proto.TableName == table {
+		return table, true
+	}
+
+	names := make([]string, 0, len(b.prototypes))
+	for name, proto := range b.prototypes {
+		if proto.TableName == table {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+
+	return names[0], true
+}
+
+func outlineKind(outline string) string {
+	trimmed := strings.TrimSpace(outline)
+	if trimmed == "" {
+		return "empty string"
+	}
+	// setters may be used unquoted, like numbers
+	if !json.Valid([]byte(varReplacementRegex.ReplaceAllString(trimmed, "null"))) {
+		return "invalid JSON"
+	}
+
+	switch trimmed[0] {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
+
+func outlineColumns(outline string) ([]string, error) {
+	var columns map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(varReplacementRegex.ReplaceAllString(outline, "null")), &columns); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(columns))
+	for column := range columns {
+		names = append(names, column)
+	}
+	sort.Strings(names)
+
+	return names, nil
 }
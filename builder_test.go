@@ -3,16 +3,32 @@ package factory_test
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Masterminds/squirrel"
-	_ "github.com/lib/pq"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 
 	"github.com/akaswenwilk/factory"
 	"github.com/stretchr/testify/suite"
 )
 
+//go:embed testdata/prototypes
+var prototypeFiles embed.FS
+
 var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}\b-[0-9a-fA-F]{4}\b-[0-9a-fA-F]{4}\b-[0-9a-fA-F]{4}\b-[0-9a-fA-F]{12}$`)
 
 type BuilderSuite struct {
@@ -36,7 +52,7 @@ func (s *BuilderSuite) TearDownSuite() {
 }
 
 func (s *BuilderSuite) SetupTest() {
-	_, err := s.db.Exec("Truncate users;")
+	_, err := s.db.Exec("Truncate users CASCADE;")
 	s.NoError(err)
 }
 
@@ -51,6 +67,37 @@ func (s *BuilderSuite) newBuilder() *factory.Builder {
 	})
 }
 
+func (s *BuilderSuite) newCaptureBuilder(statements *[]string) *factory.Builder {
+	return factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			*statements = append(*statements, sqlStatement)
+			return nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+}
+
+type recordingPersister struct {
+	rows map[string][]map[string]interface{}
+}
+
+func (p *recordingPersister) Insert(ctx context.Context, table string, row map[string]interface{}) (map[string]interface{}, error) {
+	p.rows[table] = append(p.rows[table], row)
+	return nil, nil
+}
+
+func (p *recordingPersister) Update(ctx context.Context, table string, set, where map[string]interface{}) error {
+	return nil
+}
+
+func (p *recordingPersister) Delete(ctx context.Context, table string, where map[string]interface{}) error {
+	return nil
+}
+
+func (p *recordingPersister) Query(ctx context.Context, table string, where map[string]interface{}) ([]map[string]interface{}, error) {
+	return p.rows[table], nil
+}
+
 func (s *BuilderSuite) TestNewBuilder_regularSQL() {
 	builder := s.newBuilder()
 	s.NotNil(builder)
@@ -143,13 +190,3143 @@ func (s *BuilderSuite) TestPersistInstances() {
 	}
 }
 
-func (s *BuilderSuite) TestQueryInstances() {
-	_, err := s.db.Exec("INSERT INTO users (id, username) VALUES ('123e4567-e89b-12d3-a456-426614174000', 'jenny1');")
+func (s *BuilderSuite) TestDefaults() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{})
+	builder.LoadPrototype(factory.Prototype{
+		TableName: "users",
+		Outline:   `{"id":"{{uuid}}","source":"outline"}`,
+		Defaults:  map[string]interface{}{"source": "factory", "tags": []interface{}{"seed"}},
+	})
+
+	user := builder.Build("users")
+	s.Equal("factory", user.Get("source"))
+	s.Equal([]interface{}{"seed"}, user.Get("tags"))
+	user.Get("tags").([]interface{})[0] = "changed"
+	s.Equal([]interface{}{"seed"}, builder.Build("users").Get("tags"))
+
+	overridden, err := builder.BuildE("users", map[string]interface{}{"source": "api"})
+	s.NoError(err)
+	s.Equal("api", overridden.Get("source"))
+	s.Equal("manual", builder.Build("users").With("source", "manual").Get("source"))
+}
+
+func (s *BuilderSuite) TestSQLRewrite() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			return nil
+		},
+		QueryFunc: func(ctx context.Context, sqlStatement string, args ...any) (string, error) {
+			statements = append(statements, sqlStatement)
+			return "[]", nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		SQLRewrite: func(op factory.OpKind, sql string) string {
+			switch op {
+			case factory.OpInsert:
+				return sql + " /* insert */"
+			case factory.OpUpdate:
+				return sql + " /* update */"
+			case factory.OpSelect:
+				return sql + " FOR UPDATE"
+			}
+			return sql
+		},
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"1","username":"jenny"}`})
+	user := builder.Build("users")
+	builder.Save()
+	user.With("username", "johnny")
+	builder.Save()
+	builder.Find("users", `{"username":"johnny"}`)
+	s.NoError(builder.Cleanup(context.Background()))
+
+	s.Equal([]string{
+		"INSERT INTO users (id,username) VALUES ($1,$2) /* insert */",
+		"UPDATE users SET id = $1, username = $2 WHERE id = $3 /* update */",
+		"SELECT * FROM users WHERE username = $1 FOR UPDATE",
+		"DELETE FROM users WHERE id = $1",
+	}, statements)
+}
+
+func (s *BuilderSuite) TestSaveStream() {
+	persister := factory.NewMemoryPersister()
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"{{uuid}}","total":1}`})
+
+	instances := make(chan *factory.Instance)
+	go func() {
+		defer close(instances)
+		for i := 0; i < 1200; i++ {
+			instances <- builder.BuildDetached("users")
+		}
+		instances <- builder.BuildDetached("orders")
+	}()
+
+	var progress []int
+	s.NoError(builder.SaveStream(context.Background(), instances, func(done int) {
+		progress = append(progress, done)
+	}))
+
+	// the users are only all saved once the order is
+	s.Equal([]int{500, 1000, 1201}, progress)
+	s.Len(persister.Rows("users"), 1200)
+	s.Len(persister.Rows("orders"), 1)
+	s.Empty(builder.Plan().Inserts)
+
+	// a pending order keeps the first chunk of users from being reported
+	progress = nil
+	buffered := make(chan *factory.Instance, 501)
+	buffered <- builder.BuildDetached("orders")
+	for i := 0; i < 500; i++ {
+		buffered <- builder.BuildDetached("users")
+	}
+	close(buffered)
+	s.NoError(builder.SaveStream(context.Background(), buffered, func(done int) {
+		progress = append(progress, done)
+	}))
+	s.Equal([]int{501}, progress)
+}
+
+func (s *BuilderSuite) TestSaveStreamParentsFirst() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"{{uuid}}","total":1}`})
+
+	// the orders fill a chunk while their user is still pending
+	instances := make(chan *factory.Instance, 501)
+	user := builder.BuildDetached("users")
+	instances <- user
+	for i := 0; i < 500; i++ {
+		instances <- builder.BuildDetached("orders").BelongsTo(user, "user_id")
+	}
+	close(instances)
+	s.NoError(builder.SaveStream(context.Background(), instances, nil))
+
+	s.Require().Len(statements, 2)
+	s.True(strings.HasPrefix(statements[0], "INSERT INTO users "))
+	s.True(strings.HasPrefix(statements[1], "INSERT INTO orders "))
+
+	// the children are received first and flushed once the stream ends
+	statements = nil
+	instances = make(chan *factory.Instance, 2)
+	user = builder.BuildDetached("users")
+	instances <- builder.BuildDetached("orders").BelongsTo(user, "user_id")
+	instances <- user
+	close(instances)
+	s.NoError(builder.SaveStream(context.Background(), instances, nil))
+
+	s.Require().Len(statements, 2)
+	s.True(strings.HasPrefix(statements[0], "INSERT INTO users "))
+	s.True(strings.HasPrefix(statements[1], "INSERT INTO orders "))
+}
+
+func (s *BuilderSuite) TestSaveStreamBatches() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jenny"}`})
+
+	instances := make(chan *factory.Instance, 600)
+	for i := 0; i < 600; i++ {
+		instances <- builder.BuildDetached("users")
+	}
+	close(instances)
+	s.NoError(builder.SaveStream(context.Background(), instances, nil))
+
+	s.Len(statements, 2)
+	s.Equal(strings.Count(statements[0], "),("), 499)
+	s.Equal(strings.Count(statements[1], "),("), 99)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.EqualError(builder.SaveStream(ctx, make(chan *factory.Instance), nil), "could not save stream after 0 instances: context canceled")
+}
+
+func (s *BuilderSuite) TestFoldIdentifiers() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			_, err := s.db.ExecContext(ctx, sqlStatement, args...)
+			return err
+		},
+		QueryFunc:         factory.NewQueryFunc(s.db),
+		PlaceholderFormat: squirrel.Dollar,
+		FoldIdentifiers:   true,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "tags", Outline: `{"name":"{{uuid}}","addedBy":"jenny"}`})
+	tag := builder.Build("tags")
+	builder.Save()
+
+	found := builder.Find("tags", fmt.Sprintf(`{"name":%q}`, tag.Get("name")))
+	s.Len(found, 1)
+	s.Equal("jenny", found[0].Get("addedBy"))
+}
+
+func (s *BuilderSuite) TestFoldIdentifiersMapping() {
+	persister := &recordingPersister{rows: map[string][]map[string]interface{}{
+		"users": {{"id": "1", "createdat": "today", "firstname": "jen", "other": true}},
+	}}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister, FoldIdentifiers: true})
+	builder.LoadPrototype(factory.Prototype{
+		TableName: "users",
+		Outline:   `{"id":"{{uuid}}","createdAt":"{{seqtime}}","firstName":"jen"}`,
+		ColumnMap: map[string]string{"firstName": "firstName"},
+	})
+
+	user := builder.Find("users", `{}`)[0]
+	s.JSONEq(`{"id":"1","createdAt":"today","firstName":"jen","other":true}`, user.Contents())
+
+	unfolded := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	unfolded.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"createdAt":"{{seqtime}}"}`})
+	s.Panics(func() { unfolded.Find("users", `{}`)[0].Get("createdAt") })
+}
+
+func (s *BuilderSuite) TestLoadFallibleSetterFunc() {
+	errUnavailable := errors.New("token service unavailable")
+	fail := false
+	builder := factory.NewBuilder(&factory.BuilderConfig{})
+	builder.LoadFallibleSetterFunc("token", func() (string, error) {
+		if fail {
+			return "", errUnavailable
+		}
+		return "signed", nil
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "sessions", Outline: `{"token":"{{token}}"}`})
+
+	session, err := builder.BuildE("sessions", nil)
 	s.NoError(err)
+	s.Equal("signed", session.Get("token"))
+
+	fail = true
+	_, err = builder.BuildE("sessions", nil, "failed")
+	s.ErrorIs(err, errUnavailable)
+	s.EqualError(err, "could not build instance of sessions: setter token failed: token service unavailable")
+	s.Panics(func() { builder.Build("sessions") })
+	s.Len(builder.Plan().Inserts, 1)
+}
+
+func (s *BuilderSuite) TestFindRaw() {
 	builder := s.newBuilder()
-	users := builder.Find("users", `{"username":"jenny1"}`, "alreadyExistingUser")
-	s.Equal(len(users), 1)
-	instance := users[0]
-	s.Equal(instance.Get("id"), "123e4567-e89b-12d3-a456-426614174000")
-	s.Equal(instance, builder.Instance("alreadyExistingUser", 0))
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"Jenny"}`})
+	jenny := builder.Build("users")
+	builder.Build("users").With("username", "charles")
+	builder.Save()
+
+	found := builder.FindRaw("users", "lower(username) = lower(?)", []interface{}{"JENNY"}, "jenny")
+	s.Len(found, 1)
+	s.Equal(jenny.Get("id"), found[0].Get("id"))
+	s.Equal(found[0], builder.Instance("jenny"))
+}
+
+func (s *BuilderSuite) TestFindRawStatement() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryFunc: func(ctx context.Context, sqlStatement string, args ...any) (string, error) {
+			statements = append(statements, fmt.Sprint(sqlStatement, args))
+			return `[{"id":1}]`, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+
+	s.Len(builder.FindRaw("users", "lower(email) = lower(?) AND status <> ?", []interface{}{"A@B.C", "banned"}), 1)
+	s.Equal([]string{"SELECT * FROM users WHERE lower(email) = lower($1) AND status <> $2[A@B.C banned]"}, statements)
+	s.Panics(func() { builder.FindRaw("users; --", "true", nil) })
+	s.Panics(func() {
+		factory.NewBuilder(&factory.BuilderConfig{Persister: factory.NewMemoryPersister()}).FindRaw("users", "true", nil)
+	})
+}
+
+func (s *BuilderSuite) TestColumns() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jenny","id":"{{uuid}}","status":"{{optional}}"}`})
+	user := builder.Build("users")
+	s.Equal([]string{"id", "username"}, user.Columns())
+
+	user.With("expires_at", nil).Unset("username")
+	s.Equal([]string{"expires_at", "id"}, user.Columns())
+}
+
+func (s *BuilderSuite) TestSchema() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			return nil
+		},
+		QueryFunc: func(ctx context.Context, sqlStatement string, args ...any) (string, error) {
+			statements = append(statements, sqlStatement)
+			return "[]", nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		Schema:            "tenant1",
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"1"}`})
+	user := builder.Build("users")
+	builder.Build("users").With("id", "2").Into("tenant2")
+	builder.Save()
+	user.With("username", "jenny")
+	builder.Save()
+	builder.Find("users", `{"id":"1"}`)
+	s.NoError(builder.Truncate(context.Background(), "users"))
+
+	s.Equal([]string{
+		`INSERT INTO "tenant1"."users" (id) VALUES ($1)`,
+		`INSERT INTO "tenant2"."users" (id) VALUES ($1)`,
+		`UPDATE "tenant1"."users" SET id = $1, username = $2 WHERE id = $3`,
+		`SELECT * FROM "tenant1"."users" WHERE id = $1`,
+		`TRUNCATE "tenant1"."users" RESTART IDENTITY CASCADE`,
+	}, statements)
+	s.Panics(func() { user.Into("tenant2") })
+}
+
+func (s *BuilderSuite) TestLazyBuilds() {
+	for _, batch := range []bool{false, true} {
+		persister := &recordingPersister{rows: map[string][]map[string]interface{}{}}
+		builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister, LazyBuilds: true, BatchInserts: batch})
+		builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+		builder.Build("users", "first")
+		kept := builder.Build("users", "kept").Keep()
+		builder.Build("users", "third")
+		builder.Save()
+
+		s.Len(persister.rows["users"], 1)
+		s.Equal(kept.Get("id"), persister.rows["users"][0]["id"])
+		s.Len(builder.Plan().Skips, 3)
+	}
+
+	persister := &recordingPersister{rows: map[string][]map[string]interface{}{}}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister, LazyBuilds: true})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"{{uuid}}"}`})
+	user := builder.Build("users")
+	builder.Build("orders").BelongsTo(user, "user_id")
+	builder.Build("users").With("username", "charles")
+	builder.Build("orders")
+	builder.Save()
+
+	s.Len(persister.rows["users"], 2)
+	s.Len(persister.rows["orders"], 1)
+}
+
+func (s *BuilderSuite) TestOnSave() {
+	persister := &recordingPersister{rows: map[string][]map[string]interface{}{}}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}"}`})
+
+	ids := map[string]interface{}{}
+	var calls []string
+	user := builder.Build("users", "jenny").
+		OnSave(func(i *factory.Instance) error {
+			ids["jenny"] = i.Get("id")
+			calls = append(calls, "first")
+			return nil
+		}).
+		OnSave(func(i *factory.Instance) error {
+			calls = append(calls, "second")
+			return nil
+		})
+	builder.Save()
+
+	s.Equal(map[string]interface{}{"jenny": user.Get("id")}, ids)
+	s.Equal([]string{"first", "second"}, calls)
+
+	builder.Build("users", "failing").OnSave(func(i *factory.Instance) error {
+		return errors.New("id map is full")
+	})
+	builder.Build("users", "after")
+	s.EqualError(builder.SaveE(), "error saving failing into users: on save: id map is full")
+	s.Len(persister.rows["users"], 2)
+}
+
+func (s *BuilderSuite) TestFindBuilder() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	for _, username := range []string{"charles", "anna", "bob"} {
+		builder.Build("users").With("username", username)
+	}
+	builder.Save()
+
+	found, err := builder.FindBuilder(builder.SelectBuilder("users").OrderBy("username").Limit(2), "ordered")
+	s.NoError(err)
+	s.Len(found, 2)
+	s.Equal("anna", found[0].Get("username"))
+	s.Equal("bob", found[1].Get("username"))
+	s.Equal(found[0], builder.Instance("ordered"))
+}
+
+func (s *BuilderSuite) TestFindBuilderStatement() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryFunc: func(ctx context.Context, sqlStatement string, args ...any) (string, error) {
+			statements = append(statements, fmt.Sprint(sqlStatement, args))
+			return `[{"id":1,"first_name":"jen"}]`, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		Schema:            "tenant1",
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"firstName":"jen"}`, ColumnMap: map[string]string{"firstName": "first_name"}})
+
+	sb := builder.SelectBuilder("users").Columns("count(orders.id) AS orders").
+		Join("orders ON orders.user_id = users.id").Where(squirrel.Eq{"users.status": "active"}).GroupBy("users.id")
+	found, err := builder.FindBuilder(sb)
+	s.NoError(err)
+	s.Equal("jen", found[0].Get("firstName"))
+	s.Equal([]string{`SELECT *, count(orders.id) AS orders FROM "tenant1"."users" JOIN orders ON orders.user_id = users.id WHERE users.status = $1 GROUP BY users.id[active]`}, statements)
+
+	_, err = builder.FindBuilder(squirrel.Select("1"))
+	s.EqualError(err, "could not find SELECT 1: no table selected from")
+	s.Panics(func() {
+		factory.NewBuilder(&factory.BuilderConfig{Persister: factory.NewMemoryPersister()}).SelectBuilder("users")
+	})
+}
+
+func (s *BuilderSuite) TestFindBuilderMySQLSchema() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			statements = append(statements, sqlStatement)
+			return []map[string]interface{}{{"id": int64(1), "username": "jen"}}, nil
+		},
+		PlaceholderFormat: squirrel.Question,
+		Schema:            "tenant1",
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jen"}`})
+
+	found, err := builder.FindBuilder(builder.SelectBuilder("users").Where(squirrel.Eq{"username": "jen"}))
+	s.NoError(err)
+	s.Require().Len(found, 1)
+	s.Equal("jen", found[0].Get("username"))
+	s.Equal([]string{"SELECT * FROM `tenant1`.`users` WHERE username = ?"}, statements)
+}
+
+func (s *BuilderSuite) TestDefaultFindOrderBy() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			_, err := s.db.ExecContext(ctx, sqlStatement, args...)
+			return err
+		},
+		QueryFunc:          factory.NewQueryFunc(s.db),
+		PlaceholderFormat:  squirrel.Dollar,
+		DefaultFindOrderBy: "username",
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","status":"active"}`})
+	for _, username := range []string{"charles", "anna", "bob"} {
+		builder.Build("users").With("username", username)
+	}
+	builder.Save()
+
+	var usernames []interface{}
+	for _, user := range builder.Find("users", `{"status":"active"}`) {
+		usernames = append(usernames, user.Get("username"))
+	}
+	s.Equal([]interface{}{"anna", "bob", "charles"}, usernames)
+}
+
+func (s *BuilderSuite) TestDefaultFindOrderByStatements() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryFunc: func(ctx context.Context, sqlStatement string, args ...any) (string, error) {
+			statements = append(statements, sqlStatement)
+			return `[{"aggregate":1}]`, nil
+		},
+		PlaceholderFormat:  squirrel.Dollar,
+		DefaultFindOrderBy: "id",
+	})
+	builder.Find("users", `{"status":"active"}`)
+	builder.FindRaw("users", "lower(username) = ?", []interface{}{"jenny"})
+	_, err := builder.Aggregate("users", "count(*)", `{}`)
+	s.NoError(err)
+
+	s.Equal([]string{
+		"SELECT * FROM users WHERE status = $1 ORDER BY id",
+		"SELECT * FROM users WHERE lower(username) = $1 ORDER BY id",
+		"SELECT count(*) AS aggregate FROM users",
+	}, statements)
+}
+
+func (s *BuilderSuite) TestColumnCasts() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "tags", Outline: `{"name":"{{uuid}}","kind":"topic"}`, ColumnCasts: map[string]string{"kind": "tag_kind"}})
+	tag := builder.Build("tags")
+	builder.Save()
+	tag.With("kind", "language")
+	builder.Save()
+
+	var kind string
+	s.NoError(s.db.QueryRow("SELECT kind FROM tags WHERE name = $1", tag.Get("name")).Scan(&kind))
+	s.Equal("language", kind)
+}
+
+func (s *BuilderSuite) TestColumnCastsStatements() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{
+		TableName:   "users",
+		PrimaryKey:  "id",
+		Outline:     `{"id":"1","role":"admin","lastIP":"127.0.0.1","profile":{}}`,
+		ColumnMap:   map[string]string{"lastIP": "last_ip"},
+		ColumnCasts: map[string]string{"role": "user_role", "lastIP": "inet", "profile": "jsonb"},
+	})
+	user := builder.Build("users").With("profile", factory.Default)
+	builder.Save()
+	user.With("role", "member").With("lastIP", "10.0.0.1").With("profile", factory.Default)
+	builder.Save()
+
+	s.Equal([]string{
+		"INSERT INTO users (id,last_ip,profile,role) VALUES ($1,$2::inet,DEFAULT,$3::user_role)",
+		"UPDATE users SET last_ip = $1::inet, profile = DEFAULT, role = $2::user_role WHERE id = $3",
+	}, statements)
+
+	err := builder.LoadPrototypeE(factory.Prototype{TableName: "users", Outline: `{}`, ColumnCasts: map[string]string{"role": "text; DROP TABLE users"}})
+	s.EqualError(err, `prototype users has an invalid cast "text; DROP TABLE users" for role`)
+}
+
+func (s *BuilderSuite) TestLoadPrototypesFromFS() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{})
+	s.NoError(builder.LoadPrototypesFromFS(prototypeFiles, "testdata/prototypes/*.json"))
+
+	user := builder.Build("user")
+	s.Equal("jenny", user.Get("username"))
+	s.True(uuidRegex.MatchString(user.Get("id").(string)))
+	s.Equal(float64(100), builder.Build("order").Get("total"))
+	table, columns, _, err := builder.Build("user").InsertPlan()
+	s.NoError(err)
+	s.Equal("users", table)
+	s.Equal([]string{"id", "username"}, columns)
+
+	s.ErrorContains(builder.LoadPrototypesFromFS(prototypeFiles, "testdata/prototypes/*"), "could not load prototype from testdata/prototypes/README.txt")
+	s.ErrorContains(builder.LoadPrototypeFile(prototypeFiles, "testdata/prototypes/missing.json"), "could not load prototype from testdata/prototypes/missing.json")
+}
+
+func (s *BuilderSuite) TestWriteSeedFile() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: factory.NewMemoryPersister()})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","username":"o'brien","active":true}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","total":12.5,"note":null}`})
+	// the child is built first but still written after its parent
+	order := builder.Build("orders")
+	user := builder.Build("users")
+	order.BelongsTo(user, "user_id")
+
+	var seed strings.Builder
+	s.NoError(builder.WriteSeedFile(&seed))
+
+	lines := strings.Split(strings.TrimSpace(seed.String()), "\n")
+	s.Require().Len(lines, 4)
+	s.Equal("BEGIN;", lines[0])
+	s.Equal(fmt.Sprintf("INSERT INTO users (active,id,username) VALUES (TRUE,'%s','o''brien');", user.Get("id")), lines[1])
+	s.Equal(fmt.Sprintf("INSERT INTO orders (id,note,total,user_id) VALUES ('%s',NULL,12.5,'%s');", order.Get("id"), user.Get("id")), lines[2])
+	s.Equal("COMMIT;", lines[3])
+}
+
+func (s *BuilderSuite) TestWriteSeedFileSchema() {
+	for format, table := range map[squirrel.PlaceholderFormat]string{
+		squirrel.Dollar:   `"tenant1"."users"`,
+		squirrel.Question: "`tenant1`.`users`",
+	} {
+		builder := factory.NewBuilder(&factory.BuilderConfig{
+			PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+				return nil
+			},
+			PlaceholderFormat: format,
+			Schema:            "tenant1",
+		})
+		builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"1"}`})
+		builder.Build("users")
+
+		var seed strings.Builder
+		s.NoError(builder.WriteSeedFile(&seed))
+		s.Equal("BEGIN;\nINSERT INTO "+table+" (id) VALUES ('1');\nCOMMIT;\n", seed.String())
+	}
+}
+
+func (s *BuilderSuite) TestLoadTableSetterFunc() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: factory.NewMemoryPersister()})
+	builder.LoadSetterFunc("status", func() string { return "unknown" })
+	builder.LoadTableSetterFunc("users", "status", func() string { return "active" })
+	builder.LoadTableSetterFunc("orders", "status", func() string { return "pending" })
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"status":"{{status}}"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"status":"{{status}}"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "tags", Outline: `{"status":"{{status}}"}`})
+
+	s.Equal("active", builder.Build("users").Get("status"))
+	s.Equal("pending", builder.Build("orders").Get("status"))
+	s.Equal("unknown", builder.Build("tags").Get("status"))
+	s.Panics(func() { builder.LoadTableSetterFunc("users", "bad name", func() string { return "" }) })
+}
+
+func (s *BuilderSuite) TestForgetUnpersisted() {
+	persister := factory.NewMemoryPersister()
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "responses", Outline: `{"id":"{{uuid}}"}`, BuildOnly: true})
+	builder.Build("users", "saved")
+	builder.Save()
+	builder.Build("users", "unsaved")
+	builder.Build("responses", "response")
+
+	builder.ForgetUnpersisted()
+
+	s.NotPanics(func() { builder.Instance("saved") })
+	s.Panics(func() { builder.Instance("unsaved") })
+	s.Panics(func() { builder.Instance("response") })
+	builder.Save()
+	s.Len(persister.Rows("users"), 1)
+	s.NoError(builder.Cleanup(context.Background()))
+	s.Empty(persister.Rows("users"))
+}
+
+func (s *BuilderSuite) TestGetCopy() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jenny","profile":{"age":30,"tags":["a"]}}`})
+	user := builder.Build("users")
+
+	profile := user.GetCopy("profile").(map[string]interface{})
+	profile["age"] = 31
+	profile["tags"].([]interface{})[0] = "b"
+
+	s.Equal(map[string]interface{}{"age": float64(30), "tags": []interface{}{"a"}}, user.Get("profile"))
+	s.Equal("jenny", user.GetCopy("username"))
+	s.Panics(func() { user.GetCopy("missing") })
+}
+
+func (s *BuilderSuite) TestMemoryPersister() {
+	persister := factory.NewMemoryPersister()
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","username":"jenny","status":"active"}`})
+	jenny := builder.Build("users", "jenny")
+	charles := builder.Build("users", "charles").With("username", "charles").With("status", "trial")
+	builder.Build("users", "defaulted").With("username", "defaulted").With("status", factory.Default)
+	builder.Save()
+
+	s.Len(persister.Rows("users"), 3)
+	s.Equal(jenny.Get("id"), builder.Find("users", `{"username":"jenny"}`)[0].Get("id"))
+	s.Len(builder.Find("users", `{"$or":[{"status":"trial"},{"username":"jenny"}]}`), 2)
+	s.Len(builder.Find("users", `{"status":["active","trial"]}`), 2)
+
+	charles.With("username", "chuck")
+	builder.Save()
+	found := builder.Find("users", `{"username":"chuck"}`)
+	s.Len(found, 1)
+	s.Equal(charles.Get("id"), found[0].Get("id"))
+
+	s.NoError(builder.Cleanup(context.Background()))
+	s.Empty(persister.Rows("users"))
+
+	builder.Build("users").With("username", factory.Raw("upper('jenny')"))
+	s.ErrorContains(builder.SaveE(), "raw sql expressions are not supported by the memory persister")
+}
+
+func (s *BuilderSuite) TestJSONDiff() {
+	persister := &recordingPersister{rows: map[string][]map[string]interface{}{
+		"users": {{"id": int64(1), "username": "johnny", "profile": []byte(`{"age":30}`), "status": "active"}},
+	}}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":1,"username":"jenny","profile":{"age":30},"nickname":"jen"}`})
+	user := builder.Build("users", "jenny")
+
+	diff, err := user.JSONDiff(context.Background())
+	s.NoError(err)
+	s.Equal("--- jenny\n+++ users\n-nickname: \"jen\"\n-username: \"jenny\"\n+username: \"johnny\"\n", diff)
+
+	user.Unset("nickname").With("username", "johnny")
+	diff, err = user.JSONDiff(context.Background())
+	s.NoError(err)
+	s.Empty(diff)
+}
+
+func (s *BuilderSuite) TestBuildManyFunc() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+
+	users := builder.BuildManyFunc("users", 5, func(i int, user *factory.Instance) {
+		user.With("rank", i)
+	}, "ranked")
+
+	s.Len(users, 5)
+	ids := map[interface{}]bool{}
+	for i, user := range users {
+		s.Equal(i, user.Get("rank"))
+		s.Equal(user, builder.Instance("ranked", i))
+		ids[user.Get("id")] = true
+	}
+	s.Len(ids, 5)
+}
+
+func (s *BuilderSuite) TestValidateAgainstSchema() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny","profile":{"age":30}}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"{{uuid}}","total":10}`})
+	s.NoError(builder.ValidateAgainstSchema(context.Background()))
+
+	renamed, expensive, missing := "renamed", "expensive", "missing"
+	builder.LoadPrototype(factory.Prototype{Name: &renamed, TableName: "users", Outline: `{"id":"{{uuid}}","user_name":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{Name: &expensive, TableName: "orders", Outline: `{"total":"a lot","user_id":1}`})
+	builder.LoadPrototype(factory.Prototype{Name: &missing, TableName: "invoices", Outline: `{"id":"{{uuid}}"}`})
+	s.EqualError(builder.ValidateAgainstSchema(context.Background()), "outlines do not match the schema: "+
+		"expensive: total is a lot but the column is integer; expensive: user_id is 1 but the column is uuid; "+
+		"missing: table invoices does not exist; renamed: column user_name does not exist on users")
+}
+
+func (s *BuilderSuite) TestValidateAgainstSchemaColumns() {
+	persister := &recordingPersister{rows: map[string][]map[string]interface{}{
+		"information_schema.columns": {
+			{"COLUMN_NAME": []byte("id"), "DATA_TYPE": []byte("int")},
+			{"COLUMN_NAME": []byte("active"), "DATA_TYPE": []byte("tinyint")},
+			{"COLUMN_NAME": []byte("settings"), "DATA_TYPE": []byte("json")},
+			{"COLUMN_NAME": []byte("started_at"), "DATA_TYPE": []byte("datetime")},
+		},
+	}}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "accounts", Outline: `{"id":"{{uuid}}","active":true,"settings":["a"],"started_at":"{{seqtime}}"}`})
+	s.NoError(builder.ValidateAgainstSchema(context.Background()))
+
+	builder.LoadPrototype(factory.Prototype{TableName: "accounts", Outline: `{"id":"1","active":"yes","settings":{},"started_at":5}`})
+	s.EqualError(builder.ValidateAgainstSchema(context.Background()), "outlines do not match the schema: accounts: active is yes but the column is tinyint; accounts: started_at is 5 but the column is datetime")
+}
+
+func (s *BuilderSuite) TestValidateAgainstSchemaCurrentSchema() {
+	for format, statement := range map[squirrel.PlaceholderFormat]string{
+		squirrel.Dollar:   "SELECT * FROM information_schema.columns WHERE table_name = $1 AND table_schema = current_schema()",
+		squirrel.Question: "SELECT * FROM information_schema.columns WHERE table_name = ? AND table_schema = DATABASE()",
+	} {
+		var statements []string
+		builder := factory.NewBuilder(&factory.BuilderConfig{
+			QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+				statements = append(statements, sqlStatement)
+				return []map[string]interface{}{{"column_name": "id", "data_type": "text"}}, nil
+			},
+			PlaceholderFormat:  format,
+			DefaultFindOrderBy: "id",
+		})
+		builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"1"}`})
+
+		s.NoError(builder.ValidateAgainstSchema(context.Background()))
+		s.Equal([]string{statement}, statements)
+	}
+}
+
+func (s *BuilderSuite) TestFindInto() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	user := builder.Build("users", "jenny")
+	builder.Save()
+
+	_, err := s.db.Exec("UPDATE users SET username = 'johnny' WHERE id = $1", user.Get("id"))
+	s.NoError(err)
+
+	s.NoError(builder.FindInto(user, fmt.Sprintf(`{"id":%q}`, user.Get("id"))))
+	s.Equal("johnny", user.Get("username"))
+	s.Same(user, builder.Instance("jenny"))
+
+	columns, persisted := user.PendingUpdate()
+	s.True(persisted)
+	s.Empty(columns)
+
+	s.EqualError(builder.FindInto(user, `{"username":"nobody"}`), `could not find {"username":"nobody"} into jenny: expected 1 row, found 0`)
+}
+
+func (s *BuilderSuite) TestSaveWhere() {
+	persister := &recordingPersister{rows: map[string][]map[string]interface{}{}}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}"}`})
+	builder.BuildManyFunc("users", 10, func(i int, user *factory.Instance) {
+		user.With("rank", i)
+	})
+
+	s.NoError(builder.SaveWhere(func(user *factory.Instance) bool {
+		return user.Get("rank").(int)%2 == 0
+	}))
+
+	var ranks []interface{}
+	for _, row := range persister.rows["users"] {
+		ranks = append(ranks, row["rank"])
+	}
+	s.Equal([]interface{}{0, 2, 4, 6, 8}, ranks)
+
+	s.NoError(builder.SaveWhere(func(*factory.Instance) bool { return true }))
+	s.Len(persister.rows["users"], 10)
+}
+
+func (s *BuilderSuite) TestConflictColumns() {
+	_, err := s.db.Exec("TRUNCATE members RESTART IDENTITY")
+	s.NoError(err)
+
+	member := factory.Prototype{TableName: "members", PrimaryKey: "id", ConflictColumns: []string{"tenant_id", "email"}, Outline: `{"tenant_id":1,"email":"jenny@example.com"}`}
+	first := s.newBuilder()
+	first.LoadPrototype(member)
+	jenny := first.Build("members").With("name", "jenny")
+	first.Save()
+
+	second := s.newBuilder()
+	second.LoadPrototype(member)
+	renamed := second.Build("members").With("name", "jen")
+	s.NoError(second.SaveE())
+	s.Equal(jenny.Get("id"), renamed.Get("id"))
+
+	var (
+		count       int
+		tenantID    int
+		email, name string
+	)
+	s.NoError(s.db.QueryRow("SELECT count(*) FROM members").Scan(&count))
+	s.Equal(1, count)
+	s.NoError(s.db.QueryRow("SELECT tenant_id, email, name FROM members").Scan(&tenantID, &email, &name))
+	s.Equal(1, tenantID)
+	s.Equal("jenny@example.com", email)
+	s.Equal("jen", name)
+}
+
+func (s *BuilderSuite) TestConflictColumnsStatements() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{TableName: "members", PrimaryKey: "id", ConflictColumns: []string{"tenantID", "email"}, ColumnMap: map[string]string{"tenantID": "tenant_id"}, Outline: `{"id":1,"tenantID":1,"email":"jenny@example.com","name":"jenny"}`})
+	builder.Build("members")
+	builder.Save()
+
+	mysqlBuilder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			return nil
+		},
+	})
+	mysqlBuilder.LoadPrototype(factory.Prototype{TableName: "members", ConflictColumns: []string{"tenant_id", "email"}, Outline: `{"tenant_id":1,"email":"jenny@example.com","name":"jenny"}`})
+	mysqlBuilder.Build("members")
+	mysqlBuilder.Save()
+
+	s.Equal([]string{
+		"INSERT INTO members (email,id,name,tenant_id) VALUES ($1,$2,$3,$4) ON CONFLICT (tenant_id, email) DO UPDATE SET name = EXCLUDED.name",
+		"INSERT INTO members (email,name,tenant_id) VALUES (?,?,?) ON DUPLICATE KEY UPDATE name = VALUES(name)",
+	}, statements)
+}
+
+func (s *BuilderSuite) TestConflictDoNothing() {
+	_, err := s.db.Exec("TRUNCATE members RESTART IDENTITY")
+	s.NoError(err)
+
+	member := factory.Prototype{TableName: "members", ConflictColumns: []string{"tenant_id", "email"}, ConflictDoNothing: true, Outline: `{"tenant_id":1,"email":"jenny@example.com","name":"jenny"}`}
+	first := s.newBuilder()
+	first.LoadPrototype(member)
+	jenny := first.Build("members")
+	first.Save()
+	s.True(jenny.WasInserted())
+
+	second := s.newBuilder()
+	second.LoadPrototype(member)
+	again := second.Build("members").With("name", "jen")
+	s.NoError(second.SaveE())
+	s.False(again.WasInserted())
+	s.NoError(second.Cleanup(context.Background()))
+
+	var name string
+	s.NoError(s.db.QueryRow("SELECT name FROM members").Scan(&name))
+	s.Equal("jenny", name)
+}
+
+func (s *BuilderSuite) TestConflictDoNothingStatements() {
+	var (
+		statements []string
+		existing   = map[string]bool{}
+	)
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistResultFunc: func(ctx context.Context, sqlStatement string, args ...any) (sql.Result, error) {
+			statements = append(statements, sqlStatement)
+			email := args[0].(string)
+			if existing[email] {
+				return driver.RowsAffected(0), nil
+			}
+			existing[email] = true
+			return driver.RowsAffected(1), nil
+		},
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "members", ConflictColumns: []string{"tenant_id", "email"}, ConflictDoNothing: true, Outline: `{"tenant_id":1,"email":"jenny@example.com"}`})
+	first := builder.Build("members", "first")
+	second := builder.Build("members", "second")
+	builder.Save()
+
+	s.True(first.WasInserted())
+	s.False(second.WasInserted())
+	s.Equal([]string{
+		"INSERT INTO members (email,tenant_id) VALUES (?,?) ON DUPLICATE KEY UPDATE tenant_id = tenant_id",
+		"INSERT INTO members (email,tenant_id) VALUES (?,?) ON DUPLICATE KEY UPDATE tenant_id = tenant_id",
+	}, statements)
+
+	statements = nil
+	postgres := s.newCaptureBuilder(&statements)
+	postgres.LoadPrototype(factory.Prototype{TableName: "members", ConflictDoNothing: true, Outline: `{"tenant_id":1,"email":"jenny@example.com"}`})
+	postgres.Build("members")
+	postgres.Save()
+	s.Equal([]string{"INSERT INTO members (email,tenant_id) VALUES ($1,$2) ON CONFLICT DO NOTHING"}, statements)
+}
+
+func (s *BuilderSuite) TestBatchReturning() {
+	_, err := s.db.Exec("TRUNCATE tags RESTART IDENTITY")
+	s.NoError(err)
+
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			_, err := s.db.ExecContext(ctx, sqlStatement, args...)
+			return err
+		},
+		QueryRowsFunc:     factory.NewQueryRowsFunc(s.db),
+		PlaceholderFormat: squirrel.Dollar,
+		BatchInserts:      true,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "tags", AutoIncrementColumn: "id", Returning: []string{"id"}, Outline: `{"name":"{{uuid}}"}`})
+	tags := builder.BuildManyFunc("tags", 5, nil)
+	builder.Save()
+
+	for idx, tag := range tags {
+		s.Equal(int64(idx+1), tag.Get("id"))
+
+		var name string
+		s.NoError(s.db.QueryRow("SELECT name FROM tags WHERE id = $1", tag.Get("id")).Scan(&name))
+		s.Equal(tag.Get("name"), name)
+	}
+}
+
+func (s *BuilderSuite) TestBatchReturningStatements() {
+	var statements []string
+	nextID := int64(0)
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			return nil
+		},
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			statements = append(statements, sqlStatement)
+			var rows []map[string]interface{}
+			for range args {
+				nextID++
+				rows = append(rows, map[string]interface{}{"id": nextID})
+			}
+			return rows, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		BatchInserts:      true,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "tags", AutoIncrementColumn: "id", Returning: []string{"id"}, Outline: `{"name":"{{uuid}}"}`})
+	tags := builder.BuildManyFunc("tags", 3, nil)
+	builder.Save()
+	single := builder.Build("tags")
+	builder.Save()
+
+	s.Equal([]string{
+		"INSERT INTO tags (name) VALUES ($1),($2),($3) RETURNING id",
+		"INSERT INTO tags (name) VALUES ($1) RETURNING id",
+	}, statements)
+	for idx, tag := range tags {
+		s.Equal(int64(idx+1), tag.Get("id"))
+	}
+	s.Equal(int64(4), single.Get("id"))
+}
+
+func (s *BuilderSuite) TestBatchReturningMatchesPrimaryKey() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			statements = append(statements, sqlStatement)
+			// the rows come back in reverse, ranked by the id they were inserted with
+			rows := make([]map[string]interface{}, len(args))
+			for idx, arg := range args {
+				rows[len(args)-1-idx] = map[string]interface{}{"id": arg, "rank": "rank-" + arg.(string)}
+			}
+			return rows, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		BatchInserts:      true,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "tags", PrimaryKey: "id", Returning: []string{"rank"}, Outline: `{"id":"{{uuid}}"}`})
+	tags := builder.BuildManyFunc("tags", 3, nil)
+	builder.Save()
+
+	s.Equal([]string{"INSERT INTO tags (id) VALUES ($1),($2),($3) RETURNING rank, id"}, statements)
+	for _, tag := range tags {
+		s.Equal("rank-"+tag.Get("id").(string), tag.Get("rank"))
+	}
+}
+
+func (s *BuilderSuite) TestAssertPersisted() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","username":"jenny","profile":{"age":30},"status":"{{optional}}"}`})
+	user := builder.Build("users")
+	builder.Save()
+	s.NoError(user.AssertPersisted(context.Background()))
+
+	_, err := s.db.Exec("UPDATE users SET username = 'johnny', profile = '{\"age\":31}' WHERE id = $1", user.Get("id"))
+	s.NoError(err)
+	s.EqualError(user.AssertPersisted(context.Background()), "users does not match its row in users: profile: expected map[age:30], got map[age:31]; username: expected jenny, got johnny")
+}
+
+func (s *BuilderSuite) TestAssertPersistedMismatches() {
+	persister := &recordingPersister{rows: map[string][]map[string]interface{}{
+		"users": {{"id": int64(1), "username": "johnny", "profile": []byte(`{"age":30}`)}},
+	}}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":1,"username":"jenny","profile":{"age":30},"nickname":"jen"}`})
+	user := builder.Build("users")
+
+	s.EqualError(user.AssertPersisted(context.Background()), "users does not match its row in users: nickname: expected jen, column missing; username: expected jenny, got johnny")
+
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"total":1}`})
+	s.EqualError(builder.Build("orders").AssertPersisted(context.Background()), "could not check orders: no primary key")
+}
+
+func (s *BuilderSuite) TestQueryInstances() {
+	_, err := s.db.Exec("INSERT INTO users (id, username) VALUES ('123e4567-e89b-12d3-a456-426614174000', 'jenny1');")
+	s.NoError(err)
+	builder := s.newBuilder()
+	users := builder.Find("users", `{"username":"jenny1"}`, "alreadyExistingUser")
+	s.Equal(len(users), 1)
+	instance := users[0]
+	s.Equal(instance.Get("id"), "123e4567-e89b-12d3-a456-426614174000")
+	s.Equal(instance, builder.Instance("alreadyExistingUser", 0))
+}
+
+func (s *BuilderSuite) TestEmbedInstance() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "addresses", Outline: `{"street":"main street","city":"springfield"}`})
+	address := builder.Build("addresses")
+	user := builder.Build("users").Embed("address", address)
+
+	s.Equal(map[string]interface{}{"street": "main street", "city": "springfield"}, user.Get("address"))
+
+	builder.Save()
+	s.Len(statements, 1)
+	s.Contains(statements[0], "INSERT INTO users")
+}
+
+func (s *BuilderSuite) TestCustomPersister() {
+	persister := &recordingPersister{rows: make(map[string][]map[string]interface{})}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	instance := builder.Build("users")
+	builder.Save()
+
+	s.Len(persister.rows["users"], 1)
+	users := builder.Find("users", `{"username":"jenny"}`, "foundUser")
+	s.Len(users, 1)
+	s.Equal(instance.Get("id"), users[0].Get("id"))
+}
+
+func (s *BuilderSuite) TestHTTPPersister() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Equal(http.MethodPost, r.Method)
+		s.Equal("Bearer secret", r.Header.Get("Authorization"))
+
+		var body map[string]interface{}
+		s.NoError(json.NewDecoder(r.Body).Decode(&body))
+		body["id"] = "123e4567-e89b-12d3-a456-426614174000"
+		s.NoError(json.NewEncoder(w).Encode(body))
+	}))
+	defer server.Close()
+
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		Persister: factory.NewHTTPPersister(&factory.HTTPPersisterConfig{
+			URLs:    map[string]string{"users": server.URL + "/users"},
+			Headers: map[string]string{"Authorization": "Bearer secret"},
+		}),
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jenny"}`})
+	instance := builder.Build("users")
+	builder.Save()
+
+	s.Equal("123e4567-e89b-12d3-a456-426614174000", instance.Get("id"))
+	s.Equal("jenny", instance.Get("username"))
+}
+
+func (s *BuilderSuite) TestPendingUpdate() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	instance := builder.Build("users")
+
+	columns, ok := instance.PendingUpdate()
+	s.False(ok)
+	s.Empty(columns)
+
+	builder.Save()
+	columns, ok = instance.PendingUpdate()
+	s.True(ok)
+	s.Empty(columns)
+
+	instance.With("username", "johnny")
+	columns, ok = instance.PendingUpdate()
+	s.True(ok)
+	s.Equal([]string{"username"}, columns)
+
+	// the update writes only the pending columns
+	builder.Save()
+	s.Equal("UPDATE users SET username = $1 WHERE id = $2 AND username = $3", statements[1])
+}
+
+func (s *BuilderSuite) TestUpdateWritesChangedColumns() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			return nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		BeforePersist: func(table string, contents map[string]interface{}) error {
+			contents["updated_at"] = "now"
+			return nil
+		},
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"1","username":"jenny","status":"active"}`})
+	user := builder.Build("users")
+	builder.Save()
+	user.With("status", "inactive")
+	builder.Save()
+
+	s.Equal([]string{
+		"INSERT INTO users (id,status,updated_at,username) VALUES ($1,$2,$3,$4)",
+		"UPDATE users SET status = $1, updated_at = $2 WHERE id = $3",
+	}, statements)
+}
+
+func (s *BuilderSuite) TestUpdateFoundInstanceByPrimaryKey() {
+	_, err := s.db.Exec("INSERT INTO users (id, username) VALUES ('123e4567-e89b-12d3-a456-426614174000', 'jenny1'), ('123e4567-e89b-12d3-a456-426614174001', 'jenny2');")
+	s.NoError(err)
+
+	var rowsAffected int64
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			result, err := s.db.ExecContext(ctx, sqlStatement, args...)
+			if err != nil {
+				return err
+			}
+			rowsAffected, err = result.RowsAffected()
+			return err
+		},
+		QueryFunc:         factory.NewQueryFunc(s.db),
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`, PrimaryKey: "id"})
+
+	users := builder.Find("users", `{"username":"jenny1"}`)
+	s.Len(users, 1)
+	users[0].With("username", "johnny")
+	builder.Save()
+
+	s.Equal(int64(1), rowsAffected)
+	var username string
+	err = s.db.QueryRow("SELECT username FROM users WHERE id = $1", "123e4567-e89b-12d3-a456-426614174000").Scan(&username)
+	s.NoError(err)
+	s.Equal("johnny", username)
+}
+
+func (s *BuilderSuite) TestLoadPrototypeOutlineNotObject() {
+	builder := s.newBuilder()
+	err := builder.LoadPrototypeE(factory.Prototype{TableName: "users", Outline: `["a","b"]`})
+	s.EqualError(err, "prototype users outline must be a JSON object, got array")
+	for _, outline := range []string{`users`, `<x>`, `{"id":`} {
+		err = builder.LoadPrototypeE(factory.Prototype{TableName: "users", Outline: outline})
+		s.EqualError(err, "prototype users outline must be a JSON object, got invalid JSON")
+	}
+	err = builder.LoadPrototypeE(factory.Prototype{TableName: "users", Outline: `12`})
+	s.EqualError(err, "prototype users outline must be a JSON object, got number")
+
+	err = builder.LoadPrototypeE(factory.Prototype{TableName: "users", Outline: ` {"id":"{{uuid}}"}`})
+	s.NoError(err)
+
+	s.Panics(func() {
+		builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `"jenny"`})
+	})
+}
+
+func (s *BuilderSuite) TestNestedObjectSetterFuncs() {
+	builder := s.newBuilder()
+	builder.LoadSetterFunc("now", func() string {
+		return "2023-01-01T00:00:00Z"
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny","profile":{"avatar":"{{uuid}}","joined":"{{now}}"}}`})
+	instance := builder.Build("users")
+
+	profile := instance.Get("profile").(map[string]interface{})
+	s.Regexp(uuidRegex, profile["avatar"])
+	s.Equal("2023-01-01T00:00:00Z", profile["joined"])
+	s.Equal(instance.Get("id"), profile["avatar"])
+
+	builder.Save()
+
+	var stored string
+	err := s.db.QueryRow("SELECT profile FROM users WHERE id = $1", instance.Get("id")).Scan(&stored)
+	s.NoError(err)
+	var storedProfile map[string]interface{}
+	s.NoError(json.Unmarshal([]byte(stored), &storedProfile))
+	s.Equal(profile, storedProfile)
+}
+
+func (s *BuilderSuite) TestNewForTestCleansUp() {
+	_, err := s.db.Exec("INSERT INTO users (id, username) VALUES ('123e4567-e89b-12d3-a456-426614174000', 'existing');")
+	s.NoError(err)
+
+	var id interface{}
+	s.Run("seed", func() {
+		builder := factory.NewForTest(s.T(), &factory.BuilderConfig{
+			PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+				_, err := s.db.ExecContext(ctx, sqlStatement, args...)
+				return err
+			},
+			QueryFunc:         factory.NewQueryFunc(s.db),
+			PlaceholderFormat: squirrel.Dollar,
+		})
+		builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`, PrimaryKey: "id"})
+		id = builder.Build("users").Get("id")
+		builder.Save()
+		s.Len(builder.Find("users", `{}`), 2)
+	})
+
+	var count int
+	s.NoError(s.db.QueryRow("SELECT count(*) FROM users WHERE id = $1", id).Scan(&count))
+	s.Equal(0, count)
+	s.NoError(s.db.QueryRow("SELECT count(*) FROM users").Scan(&count))
+	s.Equal(1, count)
+}
+
+func (s *BuilderSuite) TestFoundInstanceReadOnlyColumns() {
+	_, err := s.db.Exec("INSERT INTO users (id, username) VALUES ('123e4567-e89b-12d3-a456-426614174000', 'jenny1');")
+	s.NoError(err)
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{
+		TableName:       "users",
+		Outline:         `{"id":"{{uuid}}","username":"jenny"}`,
+		PrimaryKey:      "id",
+		ReadOnlyColumns: []string{"username"},
+	})
+
+	user := builder.Find("users", `{"username":"jenny1"}`)[0]
+	user.With("username", "johnny").With("profile", map[string]interface{}{"avatar": "cat.png"})
+	builder.Save()
+
+	var (
+		username string
+		profile  string
+	)
+	err = s.db.QueryRow("SELECT username, profile FROM users WHERE id = $1", user.Get("id")).Scan(&username, &profile)
+	s.NoError(err)
+	s.Equal("jenny1", username)
+	s.JSONEq(`{"avatar":"cat.png"}`, profile)
+}
+
+func (s *BuilderSuite) TestInsertDefaultValue() {
+	var statements []string
+	captureBuilder := s.newCaptureBuilder(&statements)
+	captureBuilder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jenny"}`})
+	captureBuilder.Build("users").With("status", factory.Default)
+	captureBuilder.Save()
+	s.Len(statements, 1)
+	s.Contains(statements[0], "DEFAULT")
+	s.NotContains(statements[0], "$2")
+
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny","status":"inactive"}`})
+	instance := builder.Build("users").With("status", factory.Default)
+	builder.Save()
+
+	var status string
+	err := s.db.QueryRow("SELECT status FROM users WHERE id = $1", instance.Get("id")).Scan(&status)
+	s.NoError(err)
+	s.Equal("active", status)
+}
+
+func (s *BuilderSuite) TestDefaultValueNotKeptAfterSave() {
+	var (
+		statements    []string
+		statementArgs [][]interface{}
+	)
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			statementArgs = append(statementArgs, args)
+			return nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"1","username":"jenny"}`})
+	user := builder.Build("users").With("status", factory.Default)
+	builder.Save()
+	user.With("username", "jen")
+	builder.Save()
+	s.NoError(builder.Cleanup(context.Background()))
+
+	s.Equal([]string{
+		"INSERT INTO users (id,status,username) VALUES ($1,DEFAULT,$2)",
+		"UPDATE users SET username = $1 WHERE id = $2 AND username = $3",
+		"DELETE FROM users WHERE id = $1 AND username = $2",
+	}, statements)
+	s.Equal([]interface{}{"jen", "1", "jenny"}, statementArgs[1])
+	s.Equal([]interface{}{"1", "jen"}, statementArgs[2])
+}
+
+func (s *BuilderSuite) TestRawValueNotKeptAfterSave() {
+	var (
+		statements    []string
+		statementArgs [][]interface{}
+	)
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			statementArgs = append(statementArgs, args)
+			return nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"1","username":"jenny"}`})
+	user := builder.Build("users").With("expires_at", factory.Raw("now()"))
+	builder.Save()
+	user.With("username", "jen")
+	builder.Save()
+	user.With("expires_at", factory.Raw("now()"))
+	builder.Save()
+
+	s.Equal([]string{
+		"INSERT INTO users (expires_at,id,username) VALUES (now(),$1,$2)",
+		"UPDATE users SET username = $1 WHERE id = $2 AND username = $3",
+		"UPDATE users SET expires_at = now() WHERE id = $1 AND username = $2",
+	}, statements)
+	s.Equal([]interface{}{"jen", "1", "jenny"}, statementArgs[1])
+	s.Equal([]interface{}{"1", "jen"}, statementArgs[2])
+}
+
+func (s *BuilderSuite) TestRawValue() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`, PrimaryKey: "id"})
+	instance := builder.Build("users").With("expires_at", factory.Raw("now() + interval '1 day'"))
+	builder.Save()
+
+	var expired bool
+	err := s.db.QueryRow("SELECT expires_at < now(), username FROM users WHERE id = $1", instance.Get("id")).Scan(&expired, new(string))
+	s.NoError(err)
+	s.False(expired)
+
+	instance.With("expires_at", factory.Raw("now() - interval '1 day'")).With("username", "johnny")
+	builder.Save()
+
+	var username string
+	err = s.db.QueryRow("SELECT expires_at < now(), username FROM users WHERE id = $1", instance.Get("id")).Scan(&expired, &username)
+	s.NoError(err)
+	s.True(expired)
+	s.Equal("johnny", username)
+}
+
+func (s *BuilderSuite) TestFindAll() {
+	persister := factory.NewMemoryPersister()
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","username":"charles"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","total":250}`})
+	user := builder.Build("users")
+	builder.Build("orders")
+	builder.Build("orders").With("total", 100)
+	builder.Save()
+
+	found, err := builder.FindAll(
+		map[string]string{"users": `{"username":"charles"}`, "orders": `{"total":250}`},
+		map[string]string{"users": "queriedUser"},
+	)
+	s.NoError(err)
+	s.Require().Len(found["users"], 1)
+	s.Equal(user.Get("id"), found["users"][0].Get("id"))
+	s.Len(found["orders"], 1)
+	s.Equal(found["users"][0], builder.Instance("queriedUser"))
+
+	_, err = builder.FindAll(map[string]string{"users": `{}`, "orders": `{"total":`}, nil)
+	s.ErrorContains(err, "could not find all from orders: could not build query: json error")
+
+	// the orders are found before the users fail, and aren't kept either
+	_, err = builder.FindAll(map[string]string{"orders": `{"total":100}`, "users": `{"username":`}, map[string]string{"orders": "cheapOrder"})
+	s.ErrorContains(err, "could not find all from users: could not build query: json error")
+	s.Panics(func() { builder.Instance("cheapOrder") })
+}
+
+func (s *BuilderSuite) TestDisplayNameColumn() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			return errors.New("duplicate key")
+		},
+		DisplayNameColumn: "email",
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","email":"bob@example.com"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "tags", Outline: `{"name":"go"}`})
+	builder.Build("users")
+	builder.Build("tags")
+
+	errs := builder.SaveAll()
+	s.Require().Len(errs, 2)
+	s.ErrorContains(errs[0], "error saving users (bob@example.com) into users: could not persist")
+	s.ErrorContains(errs[1], "error saving tags into tags: could not persist")
+}
+
+func (s *BuilderSuite) TestReadOnlyPrototype() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`, PrimaryKey: "id"})
+	builder.LoadPrototype(factory.Prototype{TableName: "active_users", Outline: `{}`, PrimaryKey: "id", ReadOnly: true})
+	user := builder.Build("users")
+	builder.Save()
+
+	active := builder.Find("active_users", `{"username":"jenny"}`)
+	s.Require().Len(active, 1)
+	s.Equal(user.Get("id"), active[0].Get("id"))
+	s.NoError(builder.SaveE())
+
+	active[0].With("username", "johnny")
+	s.ErrorContains(builder.SaveE(), "could not persist: active_users is read only")
+
+	var username string
+	s.NoError(s.db.QueryRow("SELECT username FROM users WHERE id = $1", user.Get("id")).Scan(&username))
+	s.Equal("jenny", username)
+}
+
+func (s *BuilderSuite) TestReadOnlyPrototypeInMemory() {
+	persister := factory.NewMemoryPersister()
+	_, err := persister.Insert(context.Background(), "active_users", map[string]interface{}{"id": "1", "username": "jenny"})
+	s.NoError(err)
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister, BatchInserts: true})
+	builder.LoadPrototype(factory.Prototype{TableName: "active_users", Outline: `{}`, PrimaryKey: "id", ReadOnly: true})
+
+	builder.Find("active_users", `{"username":"jenny"}`)[0].With("username", "johnny")
+	s.ErrorContains(builder.SaveE(), "active_users is read only")
+	s.Equal("jenny", persister.Rows("active_users")[0]["username"])
+
+	builder.Build("active_users")
+	s.ErrorContains(builder.SaveAll()[1], "active_users is read only")
+	s.Len(persister.Rows("active_users"), 1)
+}
+
+func (s *BuilderSuite) TestDirtyComparator() {
+	jsonEqual := func(old, new interface{}) bool {
+		var o, n interface{}
+		if json.Unmarshal([]byte(old.(string)), &o) != nil || json.Unmarshal([]byte(new.(string)), &n) != nil {
+			return false
+		}
+		return reflect.DeepEqual(o, n)
+	}
+	persister := factory.NewMemoryPersister()
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{
+		TableName:       "users",
+		PrimaryKey:      "id",
+		Outline:         `{"id":"{{uuid}}","username":"jenny","profile":"{\"age\":30,\"tags\":[\"admin\"]}"}`,
+		DirtyComparator: map[string]func(old, new interface{}) bool{"profile": jsonEqual},
+	})
+	user := builder.Build("users")
+	builder.Save()
+
+	user.With("profile", `{"tags": ["admin"], "age": 30}`)
+	columns, _ := user.PendingUpdate()
+	s.Empty(columns)
+	s.Empty(builder.Plan().Updates)
+
+	user.With("profile", `{"tags":["admin"],"age":31}`).With("username", "johnny")
+	columns, _ = user.PendingUpdate()
+	s.Equal([]string{"profile", "username"}, columns)
+}
+
+func (s *BuilderSuite) TestDependencyOrder() {
+	persister := factory.NewMemoryPersister()
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", PrimaryKey: "id", Outline: `{"id":"{{uuid}}"}`})
+	order := builder.Build("orders")
+	user := builder.Build("users")
+	order.BelongsTo(user, "user_id")
+
+	instances, err := builder.DependencyOrder()
+	s.NoError(err)
+	s.Equal([]*factory.Instance{user, order}, instances)
+	s.Empty(persister.Rows("users"))
+	s.Empty(persister.Rows("orders"))
+
+	user.BelongsTo(order, "order_id")
+	_, err = builder.DependencyOrder()
+	s.ErrorContains(err, "dependency cycle detected")
+}
+
+func (s *BuilderSuite) TestAutoFlushThreshold() {
+	persister := factory.NewMemoryPersister()
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister, AutoFlushThreshold: 2})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", PrimaryKey: "id", Outline: `{"id":"{{uuid}}"}`})
+
+	first := builder.Build("users", "first")
+	builder.Build("users", "second").With("username", "johnny")
+	s.Empty(persister.Rows("users"))
+
+	// the third build flushes the two pending users
+	builder.Build("orders", "order").BelongsTo(first, "user_id")
+	s.Len(persister.Rows("users"), 2)
+	s.ElementsMatch([]interface{}{"jenny", "johnny"}, []interface{}{persister.Rows("users")[0]["username"], persister.Rows("users")[1]["username"]})
+	s.Panics(func() { builder.Instance("second") })
+	s.Empty(persister.Rows("orders"))
+
+	builder.Build("users", "fourth")
+	s.Empty(persister.Rows("orders"))
+
+	builder.Save()
+	s.Len(persister.Rows("users"), 3)
+	s.Require().Len(persister.Rows("orders"), 1)
+	s.Equal(first.Get("id"), persister.Rows("orders")[0]["user_id"])
+}
+
+func (s *BuilderSuite) TestWithJSON() {
+	type profile struct {
+		Age  int      `json:"age"`
+		Tags []string `json:"tags"`
+	}
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`, PrimaryKey: "id"})
+	instance := builder.Build("users").WithJSON("profile", profile{Age: 30, Tags: []string{"admin"}})
+	s.Equal(`{"age":30,"tags":["admin"]}`, instance.Get("profile"))
+	builder.Save()
+
+	var stored []byte
+	s.NoError(s.db.QueryRow("SELECT profile FROM users WHERE id = $1", instance.Get("id")).Scan(&stored))
+	var read profile
+	s.NoError(json.Unmarshal(stored, &read))
+	s.Equal(profile{Age: 30, Tags: []string{"admin"}}, read)
+
+	s.Panics(func() { instance.WithJSON("profile", make(chan int)) })
+	s.Equal(`{"age":30,"tags":["admin"]}`, instance.Get("profile"))
+}
+
+func (s *BuilderSuite) TestInferTableNameFromPrototypeName() {
+	builder := s.newBuilder()
+	name := "User"
+	builder.LoadPrototype(factory.Prototype{Name: &name, Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	instance := builder.Build("User")
+	builder.Save()
+
+	var username string
+	err := s.db.QueryRow("SELECT username FROM users WHERE id = $1", instance.Get("id")).Scan(&username)
+	s.NoError(err)
+	s.Equal("jenny", username)
+
+	persister := &recordingPersister{rows: make(map[string][]map[string]interface{})}
+	custom := factory.NewBuilder(&factory.BuilderConfig{Persister: persister, TableNameFunc: strings.ToLower})
+	custom.LoadPrototype(factory.Prototype{Name: &name, Outline: `{"username":"jenny"}`})
+	custom.Build("User")
+	custom.Save()
+	s.Len(persister.rows["user"], 1)
+}
+
+func (s *BuilderSuite) TestPluralize() {
+	for singular, plural := range map[string]string{
+		"User":      "users",
+		"OrderItem": "order_items",
+		"person":    "people",
+		"child":     "children",
+		"category":  "categories",
+		"day":       "days",
+		"address":   "addresses",
+		"box":       "boxes",
+		"match":     "matches",
+		"sheep":     "sheep",
+		"HTTPLog":   "http_logs",
+	} {
+		s.Equal(plural, factory.Pluralize(singular), singular)
+	}
+}
+
+func (s *BuilderSuite) TestRejectUnsafeColumnNames() {
+	builder := s.newBuilder()
+	s.PanicsWithValue(`could not query {"username = 'x' OR 1=1 --":"jenny"} from users: invalid column name "username = 'x' OR 1=1 --"`, func() {
+		builder.Find("users", `{"username = 'x' OR 1=1 --":"jenny"}`)
+	})
+
+	var statements []string
+	captureBuilder := s.newCaptureBuilder(&statements)
+	captureBuilder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","user name":"jenny"}`})
+	captureBuilder.Build("users")
+	s.Panics(func() {
+		captureBuilder.Save()
+	})
+	s.Empty(statements)
+}
+
+func (s *BuilderSuite) TestBelongsToSavesParentFirst() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"{{uuid}}","total":100}`})
+	order := builder.Build("orders")
+	user := builder.Build("users")
+	order.BelongsTo(user, "user_id")
+	s.Equal(user.Get("id"), order.Get("user_id"))
+
+	builder.Save()
+
+	var userID string
+	s.NoError(s.db.QueryRow("SELECT user_id FROM orders WHERE id = $1", order.Get("id")).Scan(&userID))
+	s.Equal(user.Get("id"), userID)
+}
+
+func (s *BuilderSuite) TestNewTxBuilder() {
+	tx, err := s.db.Begin()
+	s.Require().NoError(err)
+	defer tx.Rollback()
+
+	builder := factory.NewTxBuilder(tx, squirrel.Dollar)
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	user := builder.Build("users")
+	builder.Save()
+
+	found := builder.Find("users", `{"username":"jenny"}`)
+	s.Require().Len(found, 1)
+	s.Equal(user.Get("id"), found[0].Get("id"))
+
+	var count int
+	s.NoError(s.db.QueryRow("SELECT count(*) FROM users WHERE id = $1", user.Get("id")).Scan(&count))
+	s.Equal(0, count)
+
+	rows, err := factory.NewTxQueryFunc(tx)(context.Background(), "SELECT username FROM users WHERE id = $1", user.Get("id"))
+	s.NoError(err)
+	s.JSONEq(`[{"username":"jenny"}]`, rows)
+
+	// finds keep the column types
+	s.IsType(time.Time{}, found[0].Get("created_at"))
+	typedRows, err := factory.NewTxQueryRowsFunc(tx)(context.Background(), "SELECT created_at FROM users WHERE id = $1", user.Get("id"))
+	s.NoError(err)
+	s.Require().Len(typedRows, 1)
+	s.IsType(time.Time{}, typedRows[0]["created_at"])
+}
+
+func (s *BuilderSuite) TestScenario() {
+	persister := factory.NewMemoryPersister()
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","total":100}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "line_items", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","quantity":1}`})
+
+	instances := builder.Scenario("checkout").
+		Create("users", "buyer").With("username", "bob").
+		Child("buyer", "orders", "order", "user_id").With("total", 250).
+		Child("order", "line_items", "book", "order_id").
+		Child("order", "line_items", "pen", "order_id").With("quantity", 3).
+		Build()
+	s.Len(instances, 4)
+	s.Equal(instances["buyer"], builder.Instance("buyer"))
+	s.Equal("bob", instances["buyer"].Get("username"))
+
+	order, err := builder.DependencyOrder()
+	s.NoError(err)
+	s.Equal(instances["buyer"], order[0])
+	s.Equal(instances["order"], order[1])
+
+	builder.Save()
+	s.Len(persister.Rows("users"), 1)
+	orders := persister.Rows("orders")
+	s.Require().Len(orders, 1)
+	s.Equal(instances["buyer"].Get("id"), orders[0]["user_id"])
+	s.Equal(250, orders[0]["total"])
+	items := persister.Rows("line_items")
+	s.Require().Len(items, 2)
+	for _, item := range items {
+		s.Equal(instances["order"].Get("id"), item["order_id"])
+	}
+
+	s.Panics(func() { builder.Scenario("broken").Child("missing", "orders", "order", "user_id") })
+	s.Panics(func() { builder.Scenario("broken").Create("users", "a").Create("users", "a") })
+	s.Panics(func() { builder.Scenario("broken").With("username", "bob") })
+}
+
+func (s *BuilderSuite) TestBuildGraph() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"{{uuid}}","total":100}`})
+
+	graph := builder.BuildGraph(factory.GraphSpec{
+		Nodes: []factory.GraphNode{
+			{Prototype: "orders", Name: "first"},
+			{Prototype: "orders", Name: "second", Overrides: map[string]interface{}{"total": 250}},
+			{Prototype: "users", Name: "buyer", Overrides: map[string]interface{}{"username": "bob"}},
+		},
+		Edges: []factory.GraphEdge{
+			{Child: "first", Parent: "buyer", FKColumn: "user_id"},
+			{Child: "second", Parent: "buyer", FKColumn: "user_id"},
+		},
+	})
+	s.Len(graph, 3)
+	s.Equal(graph["buyer"].Get("id"), graph["first"].Get("user_id"))
+	s.Equal(graph["buyer"].Get("id"), graph["second"].Get("user_id"))
+	s.Equal(graph["buyer"], builder.Instance("buyer"))
+
+	builder.Save()
+
+	var count int
+	s.NoError(s.db.QueryRow("SELECT count(*) FROM orders WHERE user_id = $1", graph["buyer"].Get("id")).Scan(&count))
+	s.Equal(2, count)
+	var total int
+	s.NoError(s.db.QueryRow("SELECT total FROM orders WHERE id = $1", graph["second"].Get("id")).Scan(&total))
+	s.Equal(250, total)
+}
+
+func (s *BuilderSuite) TestAutoIncrementColumnMySQL() {
+	dsn := os.Getenv("MYSQL_DSN")
+	if dsn == "" {
+		s.T().Skip("MYSQL_DSN not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	s.Require().NoError(err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS widgets (id INT AUTO_INCREMENT PRIMARY KEY, name VARCHAR(255) NOT NULL)")
+	s.Require().NoError(err)
+
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistResultFunc: func(ctx context.Context, sqlStatement string, args ...any) (sql.Result, error) {
+			return db.ExecContext(ctx, sqlStatement, args...)
+		},
+		PlaceholderFormat: squirrel.Question,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "widgets", Outline: `{"name":"sprocket"}`, PrimaryKey: "id", AutoIncrementColumn: "id"})
+	first := builder.Build("widgets")
+	second := builder.Build("widgets")
+	builder.Save()
+
+	s.NotNil(first.Get("id"))
+	s.Equal(first.Get("id").(int64)+1, second.Get("id"))
+
+	var name string
+	s.NoError(db.QueryRow("SELECT name FROM widgets WHERE id = ?", first.Get("id")).Scan(&name))
+	s.Equal("sprocket", name)
+}
+
+func (s *BuilderSuite) TestSetterFuncNames() {
+	builder := s.newBuilder()
+	builder.LoadSetterFunc("first_name", func() string { return "jenny" })
+	builder.LoadSetterFunc("name2", func() string { return "johnny" })
+	builder.LoadSetterFunc("foo", func() string { return "unused" })
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"first":"{{first_name}}","second":"{{name2}}","spaced":"{{foo bar}}","bracket":"{{foo[0]}}"}`})
+	instance := builder.Build("users")
+
+	s.Equal("jenny", instance.Get("first"))
+	s.Equal("johnny", instance.Get("second"))
+	s.Equal("{{foo bar}}", instance.Get("spaced"))
+	s.Equal("{{foo[0]}}", instance.Get("bracket"))
+}
+
+func (s *BuilderSuite) TestNamespacedSetterFuncNames() {
+	builder := s.newBuilder()
+	builder.LoadSetterFunc("first_name", func() string { return "jenny" })
+	builder.LoadSetterFunc("faker.email", func() string { return "jenny@example.com" })
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"name":"{{first_name}}","email":"{{faker.email}}","trailing":"{{faker.}}"}`})
+	instance := builder.Build("users")
+
+	s.Equal("jenny", instance.Get("name"))
+	s.Equal("jenny@example.com", instance.Get("email"))
+	s.Equal("{{faker.}}", instance.Get("trailing"))
+	s.Panics(func() {
+		builder.LoadSetterFunc("faker.email(1)", func() string { return "" })
+	})
+}
+
+func (s *BuilderSuite) TestFindByIDsPreservesOrder() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	first := builder.Build("users").With("username", "first")
+	second := builder.Build("users").With("username", "second")
+	third := builder.Build("users").With("username", "third")
+	builder.Save()
+
+	users, err := builder.FindByIDs("users", []interface{}{third.Get("id"), first.Get("id"), second.Get("id")}, "ordered")
+	s.NoError(err)
+	s.Len(users, 3)
+	s.Equal("third", users[0].Get("username"))
+	s.Equal("first", users[1].Get("username"))
+	s.Equal("second", users[2].Get("username"))
+	s.Equal(users[1], builder.Instance("ordered", 1))
+
+	_, err = builder.FindByIDs("users", []interface{}{first.Get("id"), "123e4567-e89b-12d3-a456-426614174000"})
+	s.EqualError(err, "could not find users with id 123e4567-e89b-12d3-a456-426614174000")
+}
+
+func (s *BuilderSuite) TestDefaultIDColumn() {
+	persister := &recordingPersister{rows: make(map[string][]map[string]interface{})}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister, DefaultIDColumn: "user_uuid"})
+	builder.LoadPrototype(factory.Prototype{TableName: "accounts", Outline: `{"user_uuid":"{{uuid}}","id":"not-the-key"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "teams", Outline: `{"team_id":"{{uuid}}"}`, PrimaryKey: "team_id"})
+	builder.LoadPrototype(factory.Prototype{TableName: "memberships", Outline: `{"role":"admin"}`})
+
+	account := builder.Build("accounts")
+	team := builder.Build("teams")
+	membership := builder.Build("memberships").BelongsTo(account, "account_uuid").BelongsTo(team, "team_id")
+
+	s.Equal(account.Get("user_uuid"), membership.Get("account_uuid"))
+	s.Equal(team.Get("team_id"), membership.Get("team_id"))
+}
+
+func (s *BuilderSuite) TestQueryRowsFunc() {
+	createdAt := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var query string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			query = sqlStatement
+			return []map[string]interface{}{{"id": int64(1), "created_at": createdAt}}, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+
+	users := builder.Find("widgets", `{"id":1}`)
+	s.Equal("SELECT * FROM widgets WHERE id = $1", query)
+	s.Len(users, 1)
+	s.Equal(int64(1), users[0].Get("id"))
+	s.Equal(createdAt, users[0].Get("created_at"))
+}
+
+func (s *BuilderSuite) TestNewQueryRowsFuncPreservesTypes() {
+	_, err := s.db.Exec("INSERT INTO users (id, username) VALUES ('123e4567-e89b-12d3-a456-426614174000', 'jenny1');")
+	s.NoError(err)
+	_, err = s.db.Exec("INSERT INTO orders (id, user_id, total) VALUES ('123e4567-e89b-12d3-a456-426614174001', '123e4567-e89b-12d3-a456-426614174000', 100);")
+	s.NoError(err)
+
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc:     factory.NewQueryRowsFunc(s.db),
+		PlaceholderFormat: squirrel.Dollar,
+	})
+
+	order := builder.Find("orders", `{"id":"123e4567-e89b-12d3-a456-426614174001"}`)[0]
+	s.Equal(int64(100), order.Get("total"))
+	s.Equal("123e4567-e89b-12d3-a456-426614174000", order.Get("user_id"))
+
+	user := builder.Find("users", `{"username":"jenny1"}`)[0]
+	s.IsType(time.Time{}, user.Get("created_at"))
+	s.Equal("jenny1", user.Get("username"))
+}
+
+func (s *BuilderSuite) TestBuildSkip() {
+	builder := s.newBuilder()
+	calls := 0
+	builder.LoadSetterFunc("sequence", func() string {
+		calls++
+		return "expensive"
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"{{sequence}}","profile":{"avatar":"{{uuid}}.png"}}`})
+	instance := builder.BuildSkip("users", []string{"uuid"}, "jenny")
+
+	s.Equal(1, calls)
+	s.Equal(`{"profile":{},"username":"expensive"}`, instance.Contents())
+
+	instance.With("id", "123e4567-e89b-12d3-a456-426614174000").Unset("profile")
+	builder.Save()
+
+	var username string
+	s.NoError(s.db.QueryRow("SELECT username FROM users WHERE id = $1", "123e4567-e89b-12d3-a456-426614174000").Scan(&username))
+	s.Equal("expensive", username)
+}
+
+func (s *BuilderSuite) TestCreateReloadsDefaults() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`, PrimaryKey: "id"})
+	instance := builder.Build("users")
+	s.Panics(func() { instance.Get("created_at") })
+
+	s.NoError(instance.Create(context.Background()))
+	s.NotEmpty(instance.Get("created_at"))
+	s.Equal("active", instance.Get("status"))
+
+	s.Error(instance.Create(context.Background()))
+}
+
+func (s *BuilderSuite) TestOptionalOutlineColumns() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny","status":"{{optional}}"}`})
+	defaulted := builder.Build("users", "defaulted")
+	overridden := builder.Build("users", "overridden").With("status", "inactive")
+	s.Panics(func() { defaulted.Get("status") })
+	builder.Save()
+
+	var status string
+	s.NoError(s.db.QueryRow("SELECT status FROM users WHERE id = $1", defaulted.Get("id")).Scan(&status))
+	s.Equal("active", status)
+	s.NoError(s.db.QueryRow("SELECT status FROM users WHERE id = $1", overridden.Get("id")).Scan(&status))
+	s.Equal("inactive", status)
+}
+
+func (s *BuilderSuite) TestPrepareFind() {
+	var (
+		queries   []string
+		queryArgs [][]interface{}
+	)
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			queries = append(queries, sqlStatement)
+			queryArgs = append(queryArgs, args)
+			return []map[string]interface{}{{"username": args[1]}}, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+
+	find := builder.PrepareFind("users", `{"username":"jenny","status":"active"}`, "prepared")
+	s.Equal("jenny", find.Execute()[0].Get("username"))
+	s.Equal("johnny", find.Execute("inactive", "johnny")[0].Get("username"))
+
+	s.Equal([]string{
+		"SELECT * FROM users WHERE status = $1 AND username = $2",
+		"SELECT * FROM users WHERE status = $1 AND username = $2",
+	}, queries)
+	s.Equal([][]interface{}{{"active", "jenny"}, {"inactive", "johnny"}}, queryArgs)
+	s.Equal("johnny", builder.Instance("prepared", 1).Get("username"))
+	s.Panics(func() { find.Execute("too few") })
+}
+
+func newBenchmarkBuilder() *factory.Builder {
+	rows := []map[string]interface{}{{"id": int64(1), "username": "jenny"}}
+	return factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			return rows, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+}
+
+func BenchmarkFind(b *testing.B) {
+	builder := newBenchmarkBuilder()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		builder.Find("users", `{"username":"jenny","status":"active"}`)
+	}
+}
+
+func BenchmarkPreparedFind(b *testing.B) {
+	builder := newBenchmarkBuilder()
+	find := builder.PrepareFind("users", `{"username":"jenny","status":"active"}`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		find.Execute("active", "jenny")
+	}
+}
+
+func (s *BuilderSuite) TestInstanceEqual() {
+	persister := &recordingPersister{rows: map[string][]map[string]interface{}{
+		"orders": {{"id": "a", "total": json.Number("100"), "tags": []interface{}{int64(1)}}},
+	}}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"a","total":100,"tags":[1]}`})
+	built := builder.Build("orders")
+	found := builder.Find("orders", `{}`)[0]
+
+	s.True(built.Equal(found))
+	s.True(found.Equal(builder.Build("orders").With("total", int64(100))))
+	s.False(found.Equal(builder.Build("orders").With("total", 101)))
+	s.False(found.Equal(nil))
+}
+
+func (s *BuilderSuite) TestSavepoints() {
+	trx, err := s.db.Begin()
+	s.Require().NoError(err)
+	defer trx.Rollback()
+
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			_, err := trx.ExecContext(ctx, sqlStatement, args...)
+			return err
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.Build("users", "outer")
+	builder.Save()
+
+	s.NoError(builder.Savepoint(context.Background(), "inner_scenario"))
+	builder.Build("users", "inner")
+	builder.Save()
+
+	var count int
+	s.NoError(trx.QueryRow("SELECT count(*) FROM users").Scan(&count))
+	s.Equal(2, count)
+
+	s.NoError(builder.RollbackTo(context.Background(), "inner_scenario"))
+	s.NoError(trx.QueryRow("SELECT count(*) FROM users").Scan(&count))
+	s.Equal(1, count)
+
+	builder.Save()
+	s.NoError(trx.QueryRow("SELECT count(*) FROM users").Scan(&count))
+	s.Equal(2, count)
+
+	s.Error(builder.RollbackTo(context.Background(), "unknown"))
+	s.Error(builder.Savepoint(context.Background(), "bad name"))
+}
+
+func (s *BuilderSuite) TestRollbackKeepsRawValues() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"1","username":"jenny"}`})
+
+	builder.Build("users").With("expires_at", factory.Raw("now()"))
+	s.NoError(builder.Savepoint(context.Background(), "sp"))
+	builder.Build("users").With("id", "2").With("created_at", factory.Raw("now()"))
+	builder.Save()
+	s.NoError(builder.RollbackTo(context.Background(), "sp"))
+	builder.Save()
+
+	s.Equal([]string{
+		"SAVEPOINT sp",
+		"INSERT INTO users (expires_at,id,username) VALUES (now(),$1,$2)",
+		"INSERT INTO users (created_at,id,username) VALUES (now(),$1,$2)",
+		"ROLLBACK TO SAVEPOINT sp",
+		"INSERT INTO users (expires_at,id,username) VALUES (now(),$1,$2)",
+		"INSERT INTO users (created_at,id,username) VALUES (now(),$1,$2)",
+	}, statements)
+}
+
+func (s *BuilderSuite) TestValidateDetachedParent() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"1"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"2"}`})
+	user := builder.BuildDetached("users")
+	builder.Build("orders").BelongsTo(user, "user_id")
+
+	s.NoError(builder.Validate(context.Background()))
+	statements = nil
+	builder.Save()
+
+	s.Equal([]string{
+		"INSERT INTO users (id) VALUES ($1)",
+		"INSERT INTO orders (id,user_id) VALUES ($1,$2)",
+	}, statements)
+}
+
+func (s *BuilderSuite) TestSaveErrorContext() {
+	driverErr := errors.New("null value in column \"username\" violates not-null constraint")
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			return driverErr
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"123e4567-e89b-12d3-a456-426614174000"}`})
+	builder.Build("users", "jenny")
+
+	err := builder.SaveE()
+	s.ErrorIs(err, driverErr)
+	s.EqualError(err, `error saving jenny into users: could not persist: null value in column "username" violates not-null constraint (sql: INSERT INTO users (id) VALUES ($1), args: [123e4567-e89b-12d3-a456-426614174000])`)
+
+	var statementErr *factory.StatementError
+	s.Require().ErrorAs(err, &statementErr)
+	s.Equal("INSERT INTO users (id) VALUES ($1)", statementErr.SQL)
+	s.Equal([]interface{}{"123e4567-e89b-12d3-a456-426614174000"}, statementErr.Args)
+}
+
+func (s *BuilderSuite) TestRequiredOverrides() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{
+		TableName:         "users",
+		Outline:           `{"id":"{{uuid}}","username":"{{optional}}"}`,
+		RequiredOverrides: []string{"username"},
+	})
+
+	_, err := builder.BuildE("users", nil, "forgotten")
+	s.EqualError(err, "could not build instance of users: missing required overrides username")
+	s.Panics(func() { builder.Instance("forgotten") })
+	s.Panics(func() { builder.Build("users") })
+
+	instance, err := builder.BuildE("users", map[string]interface{}{"username": "jenny"})
+	s.NoError(err)
+	s.Equal("jenny", instance.Get("username"))
+	s.Regexp(uuidRegex, instance.Get("id"))
+}
+
+func (s *BuilderSuite) TestSaveAllCollectsErrors() {
+	var saved []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			for _, arg := range args {
+				if arg == "broken" {
+					return errors.New("constraint violation")
+				}
+			}
+			saved = append(saved, sqlStatement)
+			return nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.Build("users", "first").With("username", "broken")
+	builder.Build("users", "second")
+	builder.Build("users", "third").With("username", "broken")
+
+	errs := builder.SaveAll()
+	s.Len(errs, 2)
+	s.ErrorContains(errs[0], "error saving first into users")
+	s.ErrorContains(errs[1], "error saving third into users")
+	s.Len(saved, 1)
+}
+
+type Timestamps struct {
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type structUser struct {
+	ID       string `db:"id"`
+	Username string `db:"username" json:"name"`
+	Status   string `json:"status"`
+	Ignored  string `db:"-"`
+	Timestamps
+}
+
+func (s *BuilderSuite) TestBuildFromStructAndToStruct() {
+	persister := &recordingPersister{rows: make(map[string][]map[string]interface{})}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny","status":"active"}`})
+
+	createdAt := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	instance := builder.BuildFromStruct("users", structUser{Username: "johnny", Ignored: "x", Timestamps: Timestamps{CreatedAt: createdAt}})
+	s.Regexp(uuidRegex, instance.Get("id"))
+	s.Equal("johnny", instance.Get("username"))
+	s.Equal("active", instance.Get("status"))
+	s.Equal(createdAt, instance.Get("created_at"))
+	s.Panics(func() { instance.Get("ignored") })
+
+	persister.rows["users"] = []map[string]interface{}{{
+		"id":         "123e4567-e89b-12d3-a456-426614174000",
+		"username":   "jenny",
+		"status":     "inactive",
+		"created_at": "2023-01-01T00:00:00Z",
+	}}
+	var user structUser
+	s.NoError(builder.Find("users", `{}`)[0].ToStruct(&user))
+	s.Equal(structUser{
+		ID:         "123e4567-e89b-12d3-a456-426614174000",
+		Username:   "jenny",
+		Status:     "inactive",
+		Timestamps: Timestamps{CreatedAt: createdAt},
+	}, user)
+
+	s.Error(instance.ToStruct(user))
+}
+
+func (s *BuilderSuite) TestStructTag() {
+	type taggedUser struct {
+		Username string `factory:"username" db:"user_name"`
+	}
+
+	persister := &recordingPersister{rows: make(map[string][]map[string]interface{})}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister, StructTag: "factory"})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jenny"}`})
+	instance := builder.BuildFromStruct("users", taggedUser{Username: "johnny"})
+	s.Equal(`{"username":"johnny"}`, instance.Contents())
+}
+
+func (s *BuilderSuite) TestBatchInsertsSplitByColumns() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			_, err := s.db.ExecContext(ctx, sqlStatement, args...)
+			return err
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		BatchInserts:      true,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny","status":"inactive"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"{{uuid}}","total":100}`})
+	first := builder.Build("users")
+	second := builder.Build("users").Unset("status")
+	third := builder.Build("users")
+	order := builder.Build("orders").BelongsTo(third, "user_id")
+	builder.Save()
+
+	s.Equal([]string{
+		"INSERT INTO users (id,status,username) VALUES ($1,$2,$3),($4,$5,$6)",
+		"INSERT INTO users (id,username) VALUES ($1,$2)",
+		"INSERT INTO orders (id,total,user_id) VALUES ($1,$2,$3)",
+	}, statements)
+
+	for instance, status := range map[*factory.Instance]string{first: "inactive", second: "active", third: "inactive"} {
+		var stored string
+		s.NoError(s.db.QueryRow("SELECT status FROM users WHERE id = $1", instance.Get("id")).Scan(&stored))
+		s.Equal(status, stored)
+	}
+	var count int
+	s.NoError(s.db.QueryRow("SELECT count(*) FROM orders WHERE id = $1", order.Get("id")).Scan(&count))
+	s.Equal(1, count)
+}
+
+func (s *BuilderSuite) TestDefaultPlaceholderFormat() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			return nil
+		},
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.Build("users")
+	builder.Save()
+
+	s.Equal([]string{"INSERT INTO users (id,username) VALUES (?,?)"}, statements)
+}
+
+func (s *BuilderSuite) TestFindAndOrGroups() {
+	var (
+		queries   []string
+		queryArgs [][]interface{}
+	)
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			queries = append(queries, sqlStatement)
+			queryArgs = append(queryArgs, args)
+			return []map[string]interface{}{{"username": "jenny"}}, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+
+	builder.Find("users", `{"$and":[{"tenant_id":1},{"$or":[{"status":"active"},{"status":"trial"}]}]}`)
+	builder.Find("users", `{"username":"jenny","status":"active"}`)
+
+	s.Equal([]string{
+		"SELECT * FROM users WHERE (tenant_id = $1 AND (status = $2 OR status = $3))",
+		"SELECT * FROM users WHERE status = $1 AND username = $2",
+	}, queries)
+	s.Equal([][]interface{}{{float64(1), "active", "trial"}, {"active", "jenny"}}, queryArgs)
+	s.Panics(func() { builder.Find("users", `{"$or":{"status":"active"}}`) })
+}
+
+func (s *BuilderSuite) TestOutlineMap() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{
+		TableName: "users",
+		OutlineMap: map[string]interface{}{
+			"id":       "{{uuid}}",
+			"username": "jenny",
+			"profile":  map[string]interface{}{"nickname": "jen"},
+		},
+	})
+
+	instance := builder.Build("users")
+	s.Regexp(uuidRegex, instance.Get("id"))
+	s.Equal("jenny", instance.Get("username"))
+	s.Equal(map[string]interface{}{"nickname": "jen"}, instance.Get("profile"))
+
+	err := builder.LoadPrototypeE(factory.Prototype{
+		TableName:  "users",
+		Outline:    `{"username":"jenny"}`,
+		OutlineMap: map[string]interface{}{"username": "jenny"},
+	})
+	s.EqualError(err, "prototype users cannot have both an Outline and an OutlineMap")
+}
+
+func (s *BuilderSuite) TestRefreshColumn() {
+	var queries []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			return nil
+		},
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			queries = append(queries, sqlStatement)
+			return []map[string]interface{}{{"total": int64(5)}}, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","total":1}`})
+	order := builder.Build("orders")
+	s.EqualError(order.RefreshColumn(context.Background(), "total"), "could not refresh total of orders: not persisted")
+
+	builder.Save()
+	s.NoError(order.RefreshColumn(context.Background(), "total"))
+	s.Equal(int64(5), order.Get("total"))
+	columns, _ := order.PendingUpdate()
+	s.Empty(columns)
+	s.Equal("SELECT total FROM orders WHERE id = $1", queries[len(queries)-1])
+
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jenny"}`})
+	user := builder.Build("users")
+	builder.Save()
+	s.EqualError(user.RefreshColumn(context.Background(), "username"), "could not refresh username of users: no primary key")
+}
+
+func (s *BuilderSuite) TestRowTransform() {
+	persister := &recordingPersister{rows: map[string][]map[string]interface{}{
+		"users": {{"username": "amVubnk=", "internal_flags": 3}},
+	}}
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		Persister: persister,
+		RowTransform: func(table string, row map[string]interface{}) (map[string]interface{}, error) {
+			username, err := base64.StdEncoding.DecodeString(row["username"].(string))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"username": string(username)}, nil
+		},
+	})
+
+	users := builder.Find("users", `{}`)
+	s.JSONEq(`{"username":"jenny"}`, users[0].Contents())
+
+	persister.rows["users"][0]["username"] = "not base64!"
+	s.Panics(func() { builder.Find("users", `{}`) })
+}
+
+func (s *BuilderSuite) TestStrictColumns() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{
+		TableName:     "users",
+		Outline:       `{"id":"{{uuid}}","username":"jenny","expires_at":"{{optional}}","profile":{"age":30}}`,
+		StrictColumns: true,
+	})
+	builder.Build("users")
+	builder.Build("users").With("expires_at", "2030-01-01T00:00:00Z").Unset("profile")
+	builder.Save()
+
+	s.Len(statements, 2)
+	s.Equal("INSERT INTO users (expires_at,id,profile,username) VALUES ($1,$2,$3,$4)", statements[0])
+	s.Equal(statements[0], statements[1])
+}
+
+func (s *BuilderSuite) TestEnsureReusesExistingRow() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+
+	created, wasCreated, err := builder.Ensure("users", `{"username":"reference"}`, map[string]interface{}{"status": "trial"}, "reference")
+	s.NoError(err)
+	s.True(wasCreated)
+	s.Equal("trial", created.Get("status"))
+	s.NotNil(created.Get("created_at"))
+
+	reused, wasCreated, err := builder.Ensure("users", `{"username":"reference"}`, nil, "reference")
+	s.NoError(err)
+	s.False(wasCreated)
+	s.Equal(created.Get("id"), reused.Get("id"))
+
+	var count int
+	s.NoError(s.db.QueryRow("SELECT count(*) FROM users WHERE username = 'reference'").Scan(&count))
+	s.Equal(1, count)
+}
+
+func (s *BuilderSuite) TestEnsureInsertIgnoreMySQL() {
+	var statements []string
+	var existing []map[string]interface{}
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			return existing, nil
+		},
+		PersistResultFunc: func(ctx context.Context, sqlStatement string, args ...any) (sql.Result, error) {
+			statements = append(statements, sqlStatement)
+			if len(statements) > 1 {
+				// another test inserted the row between the find and the insert
+				existing = []map[string]interface{}{{"id": int64(7), "name": "sprocket"}}
+				return driver.RowsAffected(0), nil
+			}
+			return driver.RowsAffected(1), nil
+		},
+		PlaceholderFormat: squirrel.Question,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "widgets", Outline: `{"name":"sprocket"}`, PrimaryKey: "id"})
+
+	created, wasCreated, err := builder.Ensure("widgets", `{"name":"sprocket"}`, nil)
+	s.NoError(err)
+	s.True(wasCreated)
+	s.Equal("sprocket", created.Get("name"))
+
+	reused, wasCreated, err := builder.Ensure("widgets", `{"name":"sprocket"}`, nil, "other")
+	s.NoError(err)
+	s.False(wasCreated)
+	s.Equal(int64(7), reused.Get("id"))
+
+	s.Equal([]string{"INSERT IGNORE INTO widgets (name) VALUES (?)", "INSERT IGNORE INTO widgets (name) VALUES (?)"}, statements)
+}
+
+func (s *BuilderSuite) TestTypedFactory() {
+	type User struct {
+		ID       string `db:"id"`
+		Username string `db:"username"`
+		Status   string `db:"status"`
+	}
+
+	persister := &recordingPersister{rows: make(map[string][]map[string]interface{})}
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: persister})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny","status":"active"}`})
+
+	users := factory.Typed[User](builder, "users")
+	instance := users.Build("charles", User{Username: "charles"})
+	builder.Save()
+	s.Equal("charles", builder.Instance("charles").Get("username"))
+
+	found := users.Find(`{"username":"charles"}`, "foundCharles")
+	s.Equal([]User{{ID: instance.Get("id").(string), Username: "charles", Status: "active"}}, found)
+
+	s.Panics(func() { factory.Typed[User](builder, "missing") })
+	s.Panics(func() { factory.Typed[string](builder, "users") })
+}
+
+func (s *BuilderSuite) TestFindByIDsInChunks() {
+	var queryArgs [][]interface{}
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			queryArgs = append(queryArgs, args)
+			rows := make([]map[string]interface{}, len(args))
+			for idx, arg := range args {
+				rows[idx] = map[string]interface{}{"id": arg}
+			}
+			return rows, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		FindChunkSize:     2,
+	})
+
+	ids := []interface{}{5, 4, 3, 2, 1}
+	users, err := builder.FindByIDs("users", ids)
+	s.NoError(err)
+	s.Equal([][]interface{}{{5, 4}, {3, 2}, {1}}, queryArgs)
+	s.Len(users, 5)
+	for idx, user := range users {
+		s.Equal(ids[idx], user.Get("id"))
+	}
+}
+
+func (s *BuilderSuite) TestColumnMapFindByIDsAndEnsure() {
+	var (
+		statements []string
+		insertArgs [][]interface{}
+	)
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			statements = append(statements, sqlStatement)
+			if strings.Contains(sqlStatement, "first_name") {
+				return nil, nil
+			}
+			return []map[string]interface{}{{"user_id": args[0]}}, nil
+		},
+		PersistResultFunc: func(ctx context.Context, sqlStatement string, args ...any) (sql.Result, error) {
+			statements = append(statements, sqlStatement)
+			insertArgs = append(insertArgs, args)
+			return driver.RowsAffected(1), nil
+		},
+		PlaceholderFormat: squirrel.Question,
+	})
+	builder.LoadPrototype(factory.Prototype{
+		TableName:  "users",
+		PrimaryKey: "userID",
+		Outline:    `{"userID":"1","firstName":"jen"}`,
+		ColumnMap:  map[string]string{"userID": "user_id", "firstName": "first_name"},
+	})
+
+	users, err := builder.FindByIDs("users", []interface{}{"1"})
+	s.NoError(err)
+	s.Require().Len(users, 1)
+	s.Equal("1", users[0].Get("userID"))
+
+	ensured, created, err := builder.Ensure("users", `{"first_name":"johnny"}`, map[string]interface{}{"firstName": "jenny"})
+	s.NoError(err)
+	s.True(created)
+	s.Equal("jenny", ensured.Get("firstName"))
+
+	s.Equal([]string{
+		"SELECT * FROM users WHERE user_id IN (?)",
+		"SELECT * FROM users WHERE first_name = ?",
+		"INSERT IGNORE INTO users (first_name,user_id) VALUES (?,?)",
+	}, statements)
+	s.Equal([][]interface{}{{"jenny", "1"}}, insertArgs)
+}
+
+func (s *BuilderSuite) TestDisableDefaultSetters() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			return nil
+		},
+		DisableDefaultSetters: true,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+
+	_, err := builder.BuildE("users", nil)
+	s.EqualError(err, "could not build instance of users: no setter function called uuid found")
+
+	builder.LoadSetterFunc("uuid", func() string { return "my-id" })
+	s.Equal("my-id", builder.Build("users").Get("id"))
+}
+
+func (s *BuilderSuite) TestSaveUpdatesChangedFoundInstance() {
+	var (
+		statements    []string
+		statementArgs [][]interface{}
+	)
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			statementArgs = append(statementArgs, args)
+			return nil
+		},
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			return []map[string]interface{}{{"username": "jenny"}, {"username": "johnny"}}, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+
+	users := builder.Find("users", `{}`)
+	builder.Save()
+	s.Empty(statements)
+
+	users[0].With("username", "jen")
+	builder.Save()
+	s.Equal([]string{"UPDATE users SET username = $1 WHERE username = $2"}, statements)
+	s.Equal([][]interface{}{{"jen", "jenny"}}, statementArgs)
+}
+
+func (s *BuilderSuite) TestUpdateFoundInstance() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	user := builder.Build("users")
+	builder.Save()
+
+	found := builder.Find("users", `{"username":"jenny"}`)
+	s.Len(found, 1)
+	found[0].With("username", "jen")
+	builder.Save()
+
+	var username string
+	s.NoError(s.db.QueryRow("SELECT username FROM users WHERE id = $1", user.Get("id")).Scan(&username))
+	s.Equal("jen", username)
+}
+
+func (s *BuilderSuite) TestAggregate() {
+	var queries []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			queries = append(queries, sqlStatement)
+			return []map[string]interface{}{{"aggregate": int64(len(queries))}}, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+
+	count, err := builder.Aggregate("users", "COUNT(*)", `{"status":"active"}`)
+	s.NoError(err)
+	s.Equal(int64(1), count)
+	_, err = builder.Aggregate("users", "MAX(created_at)", `{}`)
+	s.NoError(err)
+	_, err = builder.Aggregate("orders", "sum(total)", `{"user_id":"123"}`)
+	s.NoError(err)
+	s.Equal([]string{
+		"SELECT COUNT(*) AS aggregate FROM users WHERE status = $1",
+		"SELECT MAX(created_at) AS aggregate FROM users",
+		"SELECT sum(total) AS aggregate FROM orders WHERE user_id = $1",
+	}, queries)
+
+	_, err = builder.Aggregate("users", "MAX(id); DROP TABLE users", `{}`)
+	s.EqualError(err, "could not aggregate MAX(id); DROP TABLE users from users: unsupported expression")
+	s.Len(queries, 3)
+}
+
+func (s *BuilderSuite) TestAggregateDatabase() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"{{uuid}}","total":5}`})
+	user := builder.Build("users")
+	builder.Build("orders").BelongsTo(user, "user_id")
+	builder.Build("orders").BelongsTo(user, "user_id").With("total", 7)
+	builder.Save()
+
+	count, err := builder.Aggregate("orders", "COUNT(*)", `{}`)
+	s.NoError(err)
+	s.EqualValues(2, count)
+	total, err := builder.Aggregate("orders", "SUM(total)", fmt.Sprintf(`{"user_id":%q}`, user.Get("id")))
+	s.NoError(err)
+	s.EqualValues(12, total)
+	maxTotal, err := builder.Aggregate("orders", "MAX(total)", `{}`)
+	s.NoError(err)
+	s.EqualValues(7, maxTotal)
+}
+
+func (s *BuilderSuite) TestBuildDetached() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+
+	detached := builder.BuildDetached("users", "throwaway")
+	s.Equal("jenny", detached.Get("username"))
+	s.Regexp(uuidRegex, detached.Get("id"))
+	s.Panics(func() { builder.Instance("throwaway") })
+
+	builder.Build("users")
+	builder.Save()
+	s.Len(statements, 1)
+}
+
+func (s *BuilderSuite) TestColumnMap() {
+	var (
+		statements    []string
+		statementArgs [][]interface{}
+	)
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			statementArgs = append(statementArgs, args)
+			return nil
+		},
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			return []map[string]interface{}{{"user_id": "1", "first_name": "johnny"}}, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{
+		TableName:  "users",
+		Outline:    `{"userId":"1","firstName":"jen"}`,
+		PrimaryKey: "userId",
+		ColumnMap:  map[string]string{"userId": "user_id", "firstName": "first_name"},
+	})
+
+	user := builder.Build("users")
+	s.Equal("jen", user.Get("firstName"))
+	builder.Save()
+	user.With("firstName", "jenny")
+	builder.Save()
+
+	s.Equal([]string{
+		"INSERT INTO users (first_name,user_id) VALUES ($1,$2)",
+		"UPDATE users SET first_name = $1 WHERE user_id = $2",
+	}, statements)
+	s.Equal([][]interface{}{{"jen", "1"}, {"jenny", "1"}}, statementArgs)
+
+	found := builder.Find("users", `{"first_name":"johnny"}`)
+	s.Equal("johnny", found[0].Get("firstName"))
+	s.Equal("1", found[0].Get("userId"))
+}
+
+func (s *BuilderSuite) TestTruncate() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "tags", Outline: `{"name":"go"}`})
+	builder.Build("tags")
+	builder.Build("tags").With("name", "sql")
+	builder.Save()
+
+	s.NoError(builder.Truncate(context.Background()))
+	var count int
+	s.NoError(s.db.QueryRow("SELECT count(*) FROM tags").Scan(&count))
+	s.Equal(0, count)
+
+	builder.Build("tags")
+	builder.Save()
+	var id int
+	s.NoError(s.db.QueryRow("SELECT id FROM tags").Scan(&id))
+	s.Equal(1, id)
+	s.NoError(builder.Truncate(context.Background(), "tags"))
+}
+
+func (s *BuilderSuite) TestTruncateStatements() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"id":"{{uuid}}"}`})
+	builder.Build("users")
+
+	s.NoError(builder.Truncate(context.Background()))
+	builder.Save()
+	s.Equal([]string{"TRUNCATE orders, users RESTART IDENTITY CASCADE"}, statements)
+
+	statements = nil
+	mysqlBuilder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			return nil
+		},
+		PlaceholderFormat: squirrel.Question,
+	})
+	s.NoError(mysqlBuilder.Truncate(context.Background(), "orders", "users"))
+	s.Equal([]string{"SET FOREIGN_KEY_CHECKS = 0; TRUNCATE TABLE orders; TRUNCATE TABLE users; SET FOREIGN_KEY_CHECKS = 1"}, statements)
+	s.EqualError(mysqlBuilder.Truncate(context.Background(), "users; DROP TABLE users"), `could not truncate: invalid table name "users; DROP TABLE users"`)
+
+	statements = nil
+	schemaBuilder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			return nil
+		},
+		PlaceholderFormat: squirrel.Question,
+		Schema:            "tenant1",
+	})
+	s.NoError(schemaBuilder.Truncate(context.Background(), "users"))
+	s.Equal([]string{"SET FOREIGN_KEY_CHECKS = 0; TRUNCATE TABLE `tenant1`.`users`; SET FOREIGN_KEY_CHECKS = 1"}, statements)
+}
+
+func (s *BuilderSuite) TestCacheFinds() {
+	var queries int
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			return nil
+		},
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			queries++
+			return []map[string]interface{}{{"username": "jenny"}}, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		CacheFinds:        true,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"total":1}`})
+
+	first := builder.Find("users", `{"username":"jenny"}`)
+	s.Equal(first, builder.Find("users", `{"username":"jenny"}`))
+	s.Equal(1, queries)
+	builder.Find("users", `{"username":"johnny"}`)
+	s.Equal(2, queries)
+
+	// a find under another name registers its own instances
+	second := builder.Find("users", `{"username":"jenny"}`, "second")
+	s.Equal(3, queries)
+	s.Equal(second[0], builder.Instance("second"))
+	s.Equal(first[0], builder.Instance("users"))
+	s.Equal(second, builder.Find("users", `{"username":"jenny"}`, "second"))
+	s.Equal(3, queries)
+
+	builder.Build("orders")
+	builder.Save()
+	builder.Find("users", `{"username":"jenny"}`)
+	s.Equal(3, queries)
+
+	builder.Build("users")
+	builder.Save()
+	builder.Find("users", `{"username":"jenny"}`)
+	s.Equal(4, queries)
+}
+
+func (s *BuilderSuite) TestNilStrategy() {
+	for strategy, expected := range map[factory.NilStrategy]string{
+		factory.NilNull:    "INSERT INTO users (expires_at,id,username) VALUES ($1,$2,$3)",
+		factory.NilOmit:    "INSERT INTO users (id,username) VALUES ($1,$2)",
+		factory.NilDefault: "INSERT INTO users (expires_at,id,username) VALUES (DEFAULT,$1,$2)",
+	} {
+		var statements []string
+		builder := factory.NewBuilder(&factory.BuilderConfig{
+			PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+				statements = append(statements, sqlStatement)
+				return nil
+			},
+			PlaceholderFormat: squirrel.Dollar,
+			NilStrategy:       strategy,
+		})
+		builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny","expires_at":null}`})
+		builder.Build("users")
+		builder.Save()
+
+		s.Equal([]string{expected}, statements)
+	}
+}
+
+func (s *BuilderSuite) TestEmptyStringAsNullWhere() {
+	for strategy, expected := range map[factory.NilStrategy][]string{
+		factory.NilNull: {
+			"INSERT INTO tags (color,name) VALUES ($1,$2)",
+			"UPDATE tags SET name = $1 WHERE color IS NULL AND name = $2",
+			"DELETE FROM tags WHERE color IS NULL AND name = $1",
+		},
+		factory.NilDefault: {
+			"INSERT INTO tags (color,name) VALUES (DEFAULT,$1)",
+			"UPDATE tags SET name = $1 WHERE name = $2",
+			"DELETE FROM tags WHERE name = $1",
+		},
+	} {
+		var statements []string
+		builder := factory.NewBuilder(&factory.BuilderConfig{
+			PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+				statements = append(statements, sqlStatement)
+				return nil
+			},
+			PlaceholderFormat: squirrel.Dollar,
+			EmptyStringAsNull: true,
+			NilStrategy:       strategy,
+		})
+		builder.LoadPrototype(factory.Prototype{TableName: "tags", Outline: `{"name":"go","color":""}`})
+		tag := builder.Build("tags")
+		builder.Save()
+		tag.With("name", "golang")
+		builder.Save()
+		s.NoError(builder.Cleanup(context.Background()))
+
+		s.Equal(expected, statements)
+		s.Equal("", tag.Get("color"))
+	}
+}
+
+func (s *BuilderSuite) TestNilStrategyAfterSave() {
+	for strategy, expected := range map[factory.NilStrategy][]string{
+		factory.NilOmit: {
+			"INSERT INTO users (id,username) VALUES ($1,$2)",
+			"UPDATE users SET username = $1 WHERE id = $2 AND username = $3",
+			"DELETE FROM users WHERE id = $1 AND username = $2",
+		},
+		factory.NilDefault: {
+			"INSERT INTO users (expires_at,id,username) VALUES (DEFAULT,$1,$2)",
+			"UPDATE users SET username = $1 WHERE id = $2 AND username = $3",
+			"UPDATE users SET expires_at = DEFAULT WHERE id = $1 AND username = $2",
+			"DELETE FROM users WHERE id = $1 AND username = $2",
+		},
+	} {
+		var statements []string
+		builder := factory.NewBuilder(&factory.BuilderConfig{
+			PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+				statements = append(statements, sqlStatement)
+				return nil
+			},
+			PlaceholderFormat: squirrel.Dollar,
+			NilStrategy:       strategy,
+		})
+		builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"1","username":"jenny","expires_at":null}`})
+		user := builder.Build("users")
+		builder.Save()
+		user.With("username", "jen")
+		builder.Save()
+		// setting nil again writes the default again
+		user.With("expires_at", nil)
+		builder.Save()
+		s.NoError(builder.Cleanup(context.Background()))
+
+		s.Equal(expected, statements)
+	}
+}
+
+func (s *BuilderSuite) TestEmptyStringAsNull() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			_, err := s.db.ExecContext(ctx, sqlStatement, args...)
+			return err
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		EmptyStringAsNull: true,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "tags", Outline: `{"name":"{{uuid}}","addedBy":""}`})
+	tag := builder.Build("tags")
+	builder.Save()
+
+	var addedBy sql.NullString
+	s.NoError(s.db.QueryRow("SELECT addedBy FROM tags WHERE name = $1", tag.Get("name")).Scan(&addedBy))
+	s.False(addedBy.Valid)
+	s.Equal("", tag.Get("addedBy"))
+}
+
+func (s *BuilderSuite) TestEmptyStringAsNullArgs() {
+	var statementArgs [][]interface{}
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statementArgs = append(statementArgs, args)
+			return nil
+		},
+		EmptyStringAsNull: true,
+		NilStrategy:       factory.NilOmit,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"1","nickname":"","username":"jenny"}`})
+	user := builder.Build("users")
+	builder.Save()
+	user.With("username", "").With("nickname", "jen")
+	builder.Save()
+
+	s.Equal([][]interface{}{{"1", "jenny"}, {"jen", "1"}}, statementArgs)
+}
+
+func (s *BuilderSuite) TestNilStrategyStoredValues() {
+	for strategy, expected := range map[factory.NilStrategy]sql.NullString{
+		factory.NilNull:    {},
+		factory.NilOmit:    {String: "grey", Valid: true},
+		factory.NilDefault: {String: "grey", Valid: true},
+	} {
+		builder := factory.NewBuilder(&factory.BuilderConfig{
+			PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+				_, err := s.db.ExecContext(ctx, sqlStatement, args...)
+				return err
+			},
+			PlaceholderFormat: squirrel.Dollar,
+			NilStrategy:       strategy,
+		})
+		builder.LoadPrototype(factory.Prototype{TableName: "tags", Outline: `{"name":"{{uuid}}","color":null}`})
+		tag := builder.Build("tags")
+		builder.Save()
+
+		var color sql.NullString
+		s.NoError(s.db.QueryRow("SELECT color FROM tags WHERE name = $1", tag.Get("name")).Scan(&color))
+		s.Equal(expected, color)
+	}
+}
+
+func (s *BuilderSuite) TestLoadPoolSetter() {
+	builder := s.newBuilder()
+	builder.LoadPoolSetter("userID", []string{
+		"00000000-0000-0000-0000-000000000001",
+		"00000000-0000-0000-0000-000000000002",
+		"00000000-0000-0000-0000-000000000003",
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{userID}}","username":"user-{{userID}}"}`})
+
+	s.Equal("00000000-0000-0000-0000-000000000001", builder.Build("users").Get("id"))
+	s.Equal("00000000-0000-0000-0000-000000000002", builder.Build("users").Get("id"))
+	third := builder.Build("users")
+	s.Equal("00000000-0000-0000-0000-000000000003", third.Get("id"))
+	s.Equal("user-00000000-0000-0000-0000-000000000003", third.Get("username"))
+	s.Equal("00000000-0000-0000-0000-000000000001", builder.Build("users").Get("id"))
+
+	s.Panics(func() { builder.LoadPoolSetter("empty", nil) })
+}
+
+func (s *BuilderSuite) TestInsertPlan() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"1","username":"jenny","profile":{"age":30}}`})
+	user := builder.Build("users").With("status", factory.Default)
+
+	table, columns, values, err := user.InsertPlan()
+	s.NoError(err)
+	s.Equal("users", table)
+	s.Equal([]string{"id", "profile", "status", "username"}, columns)
+	s.Equal([]interface{}{"1", map[string]interface{}{"age": float64(30)}, factory.Default, "jenny"}, values)
+	s.Empty(statements)
+
+	builder.Save()
+	_, _, _, err = user.InsertPlan()
+	s.EqualError(err, "could not plan insert of users: already persisted")
+}
+
+func (s *BuilderSuite) TestCompose() {
+	builder := s.newBuilder()
+	base, withAddress, premium := "base", "with_address", "premium"
+	builder.LoadPrototype(factory.Prototype{Name: &base, TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny","status":"trial"}`})
+	builder.LoadPrototype(factory.Prototype{Name: &withAddress, Fragment: true, Outline: `{"profile":{"city":"Berlin"}}`})
+	builder.LoadPrototype(factory.Prototype{Name: &premium, Fragment: true, Outline: `{"status":"premium"}`})
+
+	user := builder.Compose([]string{"base", "with_address", "premium"}, "premiumUser")
+	s.Regexp(uuidRegex, user.Get("id"))
+	s.Equal("jenny", user.Get("username"))
+	s.Equal(map[string]interface{}{"city": "Berlin"}, user.Get("profile"))
+	s.Equal("premium", user.Get("status"))
+	s.Equal(user, builder.Instance("premiumUser"))
+
+	s.Panics(func() { builder.Build("premium") })
+	s.Panics(func() { builder.Compose([]string{"with_address", "premium"}) })
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"total":1}`})
+	s.PanicsWithValue("could not compose instance of base+orders: conflicting table names users (base) and orders (orders)", func() {
+		builder.Compose([]string{"base", "orders"})
+	})
+}
+
+func (s *BuilderSuite) TestComposeTableSetterFunc() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{Persister: factory.NewMemoryPersister()})
+	builder.LoadSetterFunc("status", func() string { return "unknown" })
+	builder.LoadTableSetterFunc("users", "status", func() string { return "active" })
+	base, trial := "base", "trial"
+	builder.LoadPrototype(factory.Prototype{Name: &base, Fragment: true, Outline: `{"status":"{{status}}"}`})
+	builder.LoadPrototype(factory.Prototype{Name: &trial, TableName: "users", Outline: `{"username":"jenny"}`})
+
+	user := builder.Compose([]string{"base", "trial"})
+	s.Equal("active", user.Get("status"))
+}
+
+func (s *BuilderSuite) TestSaveErrorReachesDriverError() {
+	builder := s.newBuilder()
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"123e4567-e89b-12d3-a456-426614174000","username":"jenny"}`})
+	builder.Build("users")
+	builder.Build("users", "duplicate")
+
+	err := builder.SaveE()
+	var pqErr *pq.Error
+	s.Require().ErrorAs(err, &pqErr)
+	s.Equal(pq.ErrorCode("23505"), pqErr.Code)
+	s.Equal("users_pkey", pqErr.Constraint)
+}
+
+func (s *BuilderSuite) TestPlan() {
+	var statements []string
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			return nil
+		},
+		QueryRowsFunc: func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+			return []map[string]interface{}{{"username": "found"}}, nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "responses", Outline: `{"body":"ok"}`, BuildOnly: true})
+
+	changed := builder.Build("users", "changed")
+	unchanged := builder.Build("users", "unchanged")
+	builder.Save()
+	changed.With("username", "jen")
+	found := builder.Find("users", `{}`)[0]
+	response := builder.Build("responses")
+	inserted := builder.Build("users", "inserted")
+
+	plan := builder.Plan()
+	s.Equal([]*factory.Instance{inserted}, plan.Inserts)
+	s.Equal([]*factory.Instance{changed}, plan.Updates)
+	s.Equal([]*factory.Instance{unchanged, found, response}, plan.Skips)
+
+	statements = nil
+	builder.Save()
+	s.Equal([]string{
+		"UPDATE users SET username = $1 WHERE username = $2",
+		"INSERT INTO users (username) VALUES ($1)",
+	}, statements)
+}
+
+func (s *BuilderSuite) TestColumnOrder() {
+	var statements []string
+	builder := s.newCaptureBuilder(&statements)
+	builder.LoadPrototype(factory.Prototype{
+		TableName:   "users",
+		PrimaryKey:  "id",
+		Outline:     `{"id":"1","username":"jenny","status":"active","expires_at":null,"profile":null}`,
+		ColumnOrder: []string{"username", "status", "id"},
+	})
+	user := builder.Build("users")
+	builder.Save()
+	user.With("profile", "{}").With("status", "inactive").With("username", "jen")
+	builder.Save()
+
+	s.Equal([]string{
+		"INSERT INTO users (username,status,id,expires_at,profile) VALUES ($1,$2,$3,$4,$5)",
+		"UPDATE users SET username = $1, status = $2, profile = $3 WHERE id = $4",
+	}, statements)
+	_, columns, _, _ := builder.Build("users").InsertPlan()
+	s.Equal([]string{"username", "status", "id", "expires_at", "profile"}, columns)
+}
+
+func (s *BuilderSuite) TestBeforePersist() {
+	var statementArgs [][]interface{}
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statementArgs = append(statementArgs, args)
+			return nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+		BeforePersist: func(table string, contents map[string]interface{}) error {
+			if contents["username"] == "invalid" {
+				return errors.New("invalid username")
+			}
+			contents["tenant_id"] = 7
+			return nil
+		},
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"jenny"}`})
+	builder.LoadPrototype(factory.Prototype{TableName: "orders", Outline: `{"total":1}`})
+	user := builder.Build("users")
+	builder.Build("orders")
+	builder.Save()
+
+	s.Equal([][]interface{}{{7, "jenny"}, {7, float64(1)}}, statementArgs)
+	s.JSONEq(`{"username":"jenny"}`, user.Contents())
+
+	builder.Build("users").With("username", "invalid")
+	s.EqualError(builder.SaveE(), "error saving users into users: could not persist: before persist: invalid username")
+}
+
+func (s *BuilderSuite) TestSeqTime() {
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		TimeBase: time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC),
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"created_at":"{{seqtime}}","updated_at":"{{seqtime}}"}`})
+
+	var previous time.Time
+	for idx := 0; idx < 3; idx++ {
+		user := builder.Build("users")
+		s.Equal(user.Get("created_at"), user.Get("updated_at"))
+
+		createdAt, err := time.Parse(time.RFC3339Nano, user.Get("created_at").(string))
+		s.NoError(err)
+		s.Equal(time.Date(2023, time.March, 1, 12, 0, idx, 0, time.UTC), createdAt)
+		s.True(createdAt.After(previous))
+		previous = createdAt
+	}
+
+	defaultBuilder := factory.NewBuilder(&factory.BuilderConfig{})
+	defaultBuilder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"created_at":"{{seqtime}}"}`})
+	s.Equal("2000-01-01T00:00:00Z", defaultBuilder.Build("users").Get("created_at"))
+}
+
+func (s *BuilderSuite) TestValidate() {
+	trx, err := s.db.Begin()
+	s.Require().NoError(err)
+	defer trx.Rollback()
+
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			_, err := trx.ExecContext(ctx, sqlStatement, args...)
+			return err
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.Build("users")
+	s.NoError(builder.Validate(context.Background()))
+
+	var count int
+	s.NoError(trx.QueryRow("SELECT count(*) FROM users").Scan(&count))
+	s.Equal(0, count)
+	s.Len(builder.Plan().Inserts, 1)
+
+	builder.Build("users", "typo").With("usernme", "jenny")
+	err = builder.Validate(context.Background())
+	s.ErrorContains(err, "could not validate: error saving typo into users")
+
+	builder.Instance("typo").Unset("usernme").With("username", "johnny")
+	builder.Save()
+	s.NoError(trx.QueryRow("SELECT count(*) FROM users").Scan(&count))
+	s.Equal(2, count)
+}
+
+func (s *BuilderSuite) TestValidateSkipsOnSave() {
+	var saved int
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			return nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+	builder.Build("users").OnSave(func(i *factory.Instance) error {
+		saved++
+		return nil
+	})
+
+	s.NoError(builder.Validate(context.Background()))
+	s.Equal(0, saved)
+
+	builder.Save()
+	s.Equal(1, saved)
+}
+
+func (s *BuilderSuite) TestAdopt() {
+	var (
+		statements    []string
+		statementArgs [][]interface{}
+	)
+	builder := factory.NewBuilder(&factory.BuilderConfig{
+		PersistFunc: func(ctx context.Context, sqlStatement string, args ...any) error {
+			statements = append(statements, sqlStatement)
+			statementArgs = append(statementArgs, args)
+			return nil
+		},
+		PlaceholderFormat: squirrel.Dollar,
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", PrimaryKey: "id", Outline: `{"id":"{{uuid}}","username":"jenny"}`})
+
+	row := map[string]interface{}{"id": "123", "username": "jenny"}
+	user := builder.Adopt("users", row, "adopted")
+	s.Equal(user, builder.Instance("adopted"))
+	builder.Save()
+	s.Empty(statements)
+
+	user.With("username", "jen")
+	builder.Save()
+	s.Equal([]string{"UPDATE users SET username = $1 WHERE id = $2"}, statements)
+	s.Equal([][]interface{}{{"jen", "123"}}, statementArgs)
+	s.Equal("jenny", row["username"])
+	s.NoError(builder.Cleanup(context.Background()))
+	s.Len(statements, 1)
+}
+
+func (s *BuilderSuite) TestLoadNamedSetterFunc() {
+	builder := s.newBuilder()
+	builder.LoadNamedSetterFunc("emailFromName", func(instanceName string) string {
+		return instanceName + "@example.com"
+	})
+	builder.LoadPrototype(factory.Prototype{TableName: "users", Outline: `{"username":"{{emailFromName}}","profile":{"email":"{{emailFromName}}"}}`})
+
+	alice := builder.Build("users", "alice")
+	s.Equal("alice@example.com", alice.Get("username"))
+	s.Equal(map[string]interface{}{"email": "alice@example.com"}, alice.Get("profile"))
+	s.Equal("users@example.com", builder.Build("users").Get("username"))
 }
@@ -0,0 +1,40 @@
+package factory
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/Masterminds/squirrel"
+)
+
+var castRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_. ]*(\[\])?$`)
+
+type columnCastsKey struct{}
+
+func withColumnCasts(ctx context.Context, proto *Prototype) context.Context {
+	if proto == nil || len(proto.ColumnCasts) == 0 {
+		return ctx
+	}
+
+	casts := make(map[string]string, len(proto.ColumnCasts))
+	for key, cast := range proto.ColumnCasts {
+		casts[proto.column(key)] = cast
+	}
+
+	return context.WithValue(ctx, columnCastsKey{}, casts)
+}
+
+// castValue binds the value of a column with a configured cast as $n::cast.
+// Default and Raw values are already sql and are left alone
+func castValue(ctx context.Context, column string, value interface{}) interface{} {
+	casts, _ := ctx.Value(columnCastsKey{}).(map[string]string)
+	cast, ok := casts[column]
+	if !ok {
+		return value
+	}
+	if _, isSQL := value.(squirrel.Sqlizer); isSQL {
+		return value
+	}
+
+	return squirrel.Expr("?::"+cast, value)
+}
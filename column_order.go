@@ -0,0 +1,49 @@
+package factory
+
+import (
+	"context"
+	"sort"
+)
+
+type columnOrderKey struct{}
+
+func withColumnOrder(ctx context.Context, proto *Prototype) context.Context {
+	if proto == nil || len(proto.ColumnOrder) == 0 {
+		return ctx
+	}
+
+	order := make([]string, len(proto.ColumnOrder))
+	for idx, key := range proto.ColumnOrder {
+		order[idx] = proto.column(key)
+	}
+
+	return context.WithValue(ctx, columnOrderKey{}, order)
+}
+
+// orderedColumns lists the columns of row in the order of the prototype being
+// persisted, followed by any other columns in alphabetical order
+func orderedColumns(ctx context.Context, row map[string]interface{}) []string {
+	order, _ := ctx.Value(columnOrderKey{}).([]string)
+	if len(order) == 0 {
+		return sortedColumns(row)
+	}
+
+	columns := make([]string, 0, len(row))
+	listed := make(map[string]bool, len(order))
+	for _, column := range order {
+		listed[column] = true
+		if _, ok := row[column]; ok {
+			columns = append(columns, column)
+		}
+	}
+
+	var rest []string
+	for column := range row {
+		if !listed[column] {
+			rest = append(rest, column)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(columns, rest...)
+}
@@ -0,0 +1,191 @@
+package factory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+func (i *Instance) Equal(other *Instance) bool {
+	if other == nil {
+		return false
+	}
+
+	return valuesEqual(i.contents, other.contents)
+}
+
+func (i *Instance) AssertPersisted(ctx context.Context) error {
+	diffs, err := i.diffStoredRow(ctx)
+	if err != nil {
+		return fmt.Errorf("could not check %s: %w", i.displayName(), err)
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+
+	mismatches := make([]string, len(diffs))
+	for idx, d := range diffs {
+		if d.missing {
+			mismatches[idx] = fmt.Sprintf("%s: expected %v, column missing", d.column, d.expected)
+		} else {
+			mismatches[idx] = fmt.Sprintf("%s: expected %v, got %v", d.column, d.expected, d.actual)
+		}
+	}
+
+	return fmt.Errorf("%s does not match its row in %s: %s", i.displayName(), i.tableName, strings.Join(mismatches, "; "))
+}
+
+func (i *Instance) JSONDiff(ctx context.Context) (string, error) {
+	diffs, err := i.diffStoredRow(ctx)
+	if err != nil {
+		return "", fmt.Errorf("could not diff %s: %w", i.displayName(), err)
+	}
+	if len(diffs) == 0 {
+		return "", nil
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", i.name, i.tableName)
+	for _, d := range diffs {
+		fmt.Fprintf(&out, "-%s: %s\n", d.column, diffValue(d.expected))
+		if !d.missing {
+			fmt.Fprintf(&out, "+%s: %s\n", d.column, diffValue(d.actual))
+		}
+	}
+
+	return out.String(), nil
+}
+
+type columnDiff struct {
+	column           string
+	expected, actual interface{}
+	missing          bool
+}
+
+// diffStoredRow selects the row of the instance by its primary key and lists
+// the columns of the instance that don't match it, sorted by column
+func (i *Instance) diffStoredRow(ctx context.Context) ([]columnDiff, error) {
+	if i.prototype == nil || i.prototype.PrimaryKey == "" {
+		return nil, fmt.Errorf("no primary key")
+	}
+	key := i.prototype.PrimaryKey
+	id, ok := i.contents[key]
+	if !ok {
+		return nil, fmt.Errorf("no %s set", key)
+	}
+
+	rows, err := i.baseBuilder.persister.Query(ctx, i.tableName, map[string]interface{}{i.prototype.column(key): id})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("expected 1 row with %s %v, found %d", key, id, len(rows))
+	}
+	row := i.prototype.fromColumns(rows[0])
+
+	var diffs []columnDiff
+	for _, column := range sortedColumns(i.contents) {
+		expected := i.contents[column]
+		switch expected.(type) {
+		case defaultValue, rawValue:
+			// the value is only known to the database
+			continue
+		}
+
+		actual, ok := row[column]
+		if !ok {
+			diffs = append(diffs, columnDiff{column: column, expected: expected, missing: true})
+			continue
+		}
+		if !storedValueEqual(expected, actual) {
+			diffs = append(diffs, columnDiff{column: column, expected: expected, actual: actual})
+		}
+	}
+
+	return diffs, nil
+}
+
+func diffValue(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	return string(encoded)
+}
+
+// json columns can come back from the driver as text, so nested values are
+// compared to the decoded column
+func storedValueEqual(expected, actual interface{}) bool {
+	if valuesEqual(expected, actual) {
+		return true
+	}
+
+	switch expected.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return false
+	}
+
+	var encoded []byte
+	switch value := actual.(type) {
+	case string:
+		encoded = []byte(value)
+	case []byte:
+		encoded = value
+	default:
+		return false
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return false
+	}
+
+	return valuesEqual(expected, decoded)
+}
+
+func valuesEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+		return value.String()
+	case []byte:
+		return string(value)
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for k, nested := range value {
+			normalized[k] = normalize(nested)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(value))
+		for idx, nested := range value {
+			normalized[idx] = normalize(nested)
+		}
+		return normalized
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+
+	return v
+}
@@ -0,0 +1,66 @@
+package factory
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (b *Builder) Compose(fragments []string, instanceName ...string) *Instance {
+	instance, err := b.compose(fragments, instanceName...)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return instance
+}
+
+func (b *Builder) compose(fragments []string, instanceName ...string) (*Instance, error) {
+	if len(fragments) == 0 {
+		return nil, fmt.Errorf("could not compose instance: no fragments given")
+	}
+	composedName := strings.Join(fragments, "+")
+
+	var (
+		tableProto *Prototype
+		tableName  string
+		protos     = make([]Prototype, len(fragments))
+	)
+	for idx, fragment := range fragments {
+		proto, ok := b.prototypes[fragment]
+		if !ok {
+			return nil, fmt.Errorf("could not compose instance of %s: no prototype found for %s", composedName, fragment)
+		}
+		protos[idx] = proto
+
+		if proto.TableName != "" {
+			if tableProto != nil && proto.TableName != tableProto.TableName {
+				return nil, fmt.Errorf("could not compose instance of %s: conflicting table names %s (%s) and %s (%s)", composedName, tableProto.TableName, tableName, proto.TableName, fragment)
+			}
+			if tableProto == nil {
+				p := proto
+				tableProto = &p
+				tableName = fragment
+			}
+		}
+	}
+
+	if tableProto == nil {
+		return nil, fmt.Errorf("could not compose instance of %s: no fragment has a table name", composedName)
+	}
+
+	// fragments have no table of their own, their setters are the ones of the table composed into
+	contents := make(map[string]interface{})
+	for idx, fragment := range fragments {
+		proto := protos[idx]
+		proto.TableName = tableProto.TableName
+		fragmentContents, err := b.outlineContents(fragment, instanceNameOr(composedName, instanceName), proto, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fragmentContents {
+			contents[k] = v
+		}
+	}
+
+	return b.newInstance(composedName, *tableProto, contents, buildOptions{}, instanceName...)
+}
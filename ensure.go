@@ -0,0 +1,112 @@
+package factory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+type insertIgnorer interface {
+	canInsertIgnore() bool
+	insertIgnore(ctx context.Context, table string, row map[string]interface{}) (map[string]interface{}, bool, error)
+}
+
+func (b *Builder) Ensure(table, query string, overrides map[string]interface{}, instanceName ...string) (*Instance, bool, error) {
+	var queryMap map[string]interface{}
+	if err := json.Unmarshal([]byte(query), &queryMap); err != nil {
+		return nil, false, fmt.Errorf("could not ensure %s from %s: json error: %s", query, table, err.Error())
+	}
+
+	found, err := b.findFirst(table, queryMap, instanceName...)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not ensure %s from %s: %w", query, table, err)
+	}
+	if found != nil {
+		return found, false, nil
+	}
+
+	prototypeName, ok := b.prototypeNameForTable(table)
+	if !ok {
+		return nil, false, fmt.Errorf("could not ensure %s from %s: no prototype found", query, table)
+	}
+
+	// the query names columns while the overrides name outline keys
+	proto := b.prototypes[prototypeName]
+	values := make(map[string]interface{}, len(queryMap)+len(overrides))
+	for k, v := range proto.fromColumns(queryMap) {
+		values[k] = v
+	}
+	for k, v := range overrides {
+		values[k] = v
+	}
+
+	instance, err := b.build(prototypeName, buildOptions{overrides: values}, instanceName...)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not ensure %s from %s: %w", query, table, err)
+	}
+
+	ctx := context.Background()
+	ignorer, ok := b.persister.(insertIgnorer)
+	if !ok || !ignorer.canInsertIgnore() {
+		if err := instance.persist(ctx, b.persister); err != nil {
+			b.forget(instance)
+			return nil, false, fmt.Errorf("could not ensure %s from %s: %w", query, table, err)
+		}
+		return instance, true, nil
+	}
+
+	if err := instance.checkWritable(); err != nil {
+		b.forget(instance)
+		return nil, false, fmt.Errorf("could not ensure %s from %s: %w", query, table, err)
+	}
+	instance.resolveAssociations()
+	row, err := instance.insertContents()
+	if err != nil {
+		b.forget(instance)
+		return nil, false, fmt.Errorf("could not ensure %s from %s: %w", query, table, err)
+	}
+
+	returned, inserted, err := ignorer.insertIgnore(withColumnCasts(withColumnOrder(ctx, instance.prototype), instance.prototype), table, row)
+	if err != nil {
+		b.forget(instance)
+		return nil, false, fmt.Errorf("could not ensure %s from %s: %w", query, table, err)
+	}
+	if inserted {
+		instance.markPersisted(returned)
+		return instance, true, nil
+	}
+
+	// the row was inserted by someone else between the find and the insert
+	b.forget(instance)
+	found, err = b.findFirst(table, queryMap, instanceName...)
+	if err == nil && found == nil {
+		err = fmt.Errorf("insert conflicted but no row matches the query")
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("could not ensure %s from %s: %w", query, table, err)
+	}
+
+	return found, false, nil
+}
+
+func (b *Builder) findFirst(table string, where map[string]interface{}, instanceName ...string) (*Instance, error) {
+	found, err := b.query(table, where, instanceName...)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	b.instances = append(b.instances, found[0])
+	return found[0], nil
+}
+
+func (b *Builder) forget(instance *Instance) {
+	for idx, i := range b.instances {
+		if i == instance {
+			b.instances = append(b.instances[:idx], b.instances[idx+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package factory
+
+func (b *Builder) cachedFind(table, query string, instanceName ...string) ([]*Instance, bool) {
+	if !b.cacheFinds {
+		return nil, false
+	}
+
+	instances, ok := b.findCache[table][findCacheKey(table, query, instanceName...)]
+	return instances, ok
+}
+
+func (b *Builder) cacheFind(table, query string, instances []*Instance, instanceName ...string) {
+	if !b.cacheFinds {
+		return
+	}
+
+	if b.findCache[table] == nil {
+		b.findCache[table] = make(map[string][]*Instance)
+	}
+	b.findCache[table][findCacheKey(table, query, instanceName...)] = instances
+}
+
+// the instances of a find are registered under its name, so a find with
+// another name has to register instances of its own
+func findCacheKey(table, query string, instanceName ...string) string {
+	return instanceNameOr(table, instanceName) + "\x00" + query
+}
+
+func (b *Builder) invalidateFinds(tables ...string) {
+	if len(tables) == 0 {
+		b.findCache = make(map[string]map[string][]*Instance)
+		return
+	}
+
+	for _, table := range tables {
+		delete(b.findCache, table)
+	}
+}
@@ -0,0 +1,53 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type rawQuerier interface {
+	queryRaw(ctx context.Context, table, whereSQL string, args []interface{}) ([]map[string]interface{}, error)
+}
+
+func (b *Builder) FindRaw(table, whereSQL string, args []interface{}, instanceName ...string) []*Instance {
+	if !identifierRegex.MatchString(table) {
+		panic(fmt.Sprintf("could not query %s from %s: invalid table name %q", whereSQL, table, table))
+	}
+
+	querier, ok := b.persister.(rawQuerier)
+	if !ok {
+		panic(fmt.Sprintf("could not query %s from %s: persister does not support raw conditions", whereSQL, table))
+	}
+
+	rows, err := querier.queryRaw(context.Background(), table, whereSQL, args)
+	if err != nil {
+		panic(fmt.Sprintf("could not query %s from %s: %s", whereSQL, table, err.Error()))
+	}
+
+	instances, err := b.instancesFromRows(table, rows, instanceName...)
+	if err != nil {
+		panic(fmt.Sprintf("could not query %s from %s: %s", whereSQL, table, err.Error()))
+	}
+
+	b.instances = append(b.instances, instances...)
+	return instances
+}
+
+// the condition is written with ? placeholders, which squirrel rebinds to the
+// placeholder format of the persister
+func (p *SQLPersister) queryRaw(ctx context.Context, table, whereSQL string, args []interface{}) ([]map[string]interface{}, error) {
+	selectBuilder := squirrel.Select("*").From(p.table(table)).Where(whereSQL, args...)
+	if p.orderBy != "" {
+		selectBuilder = selectBuilder.OrderBy(p.orderBy)
+	}
+
+	sql, args, err := selectBuilder.PlaceholderFormat(p.placeholderFormat).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpSelect, sql)
+
+	return p.queryRows(ctx, sql, args...)
+}
@@ -0,0 +1,50 @@
+package factory
+
+import "fmt"
+
+type GraphNode struct {
+	Prototype string
+	Name      string
+	Overrides map[string]interface{}
+}
+
+type GraphEdge struct {
+	Child    string
+	Parent   string
+	FKColumn string
+}
+
+type GraphSpec struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+func (b *Builder) BuildGraph(spec GraphSpec) map[string]*Instance {
+	instances := make(map[string]*Instance, len(spec.Nodes))
+	for _, node := range spec.Nodes {
+		if _, ok := instances[node.Name]; ok {
+			panic(fmt.Sprintf("could not build graph: duplicate node %s", node.Name))
+		}
+
+		instance := b.Build(node.Prototype, node.Name)
+		for k, v := range node.Overrides {
+			instance.With(k, v)
+		}
+		instances[node.Name] = instance
+	}
+
+	for _, edge := range spec.Edges {
+		child, ok := instances[edge.Child]
+		if !ok {
+			panic(fmt.Sprintf("could not build graph: no node %s found", edge.Child))
+		}
+		parent, ok := instances[edge.Parent]
+		if !ok {
+			panic(fmt.Sprintf("could not build graph: no node %s found", edge.Parent))
+		}
+
+		child.BelongsTo(parent, edge.FKColumn)
+	}
+
+	return instances
+}
@@ -0,0 +1,120 @@
+package factory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+var errHTTPOperationNotSupported = errors.New("operation not supported by http persister")
+
+type HTTPPersister struct {
+	urls    map[string]string
+	headers map[string]string
+	client  *http.Client
+}
+
+type HTTPPersisterConfig struct {
+	URLs    map[string]string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+func NewHTTPPersister(config *HTTPPersisterConfig) *HTTPPersister {
+	client := config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTPPersister{
+		urls:    config.URLs,
+		headers: config.Headers,
+		client:  client,
+	}
+}
+
+func (p *HTTPPersister) Insert(ctx context.Context, table string, row map[string]interface{}) (map[string]interface{}, error) {
+	body, err := json.Marshal(row)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal %s: %w", table, err)
+	}
+
+	var created map[string]interface{}
+	err = p.do(ctx, http.MethodPost, table, nil, body, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+func (p *HTTPPersister) Update(ctx context.Context, table string, set, where map[string]interface{}) error {
+	return fmt.Errorf("could not update %s: %w", table, errHTTPOperationNotSupported)
+}
+
+func (p *HTTPPersister) Delete(ctx context.Context, table string, where map[string]interface{}) error {
+	return fmt.Errorf("could not delete %s: %w", table, errHTTPOperationNotSupported)
+}
+
+func (p *HTTPPersister) Query(ctx context.Context, table string, where map[string]interface{}) ([]map[string]interface{}, error) {
+	params := url.Values{}
+	for k, v := range where {
+		params.Set(k, fmt.Sprintf("%v", v))
+	}
+
+	var rows []map[string]interface{}
+	err := p.do(ctx, http.MethodGet, table, params, nil, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (p *HTTPPersister) do(ctx context.Context, method, table string, params url.Values, body []byte, result interface{}) error {
+	target, ok := p.urls[table]
+	if !ok {
+		return fmt.Errorf("no url configured for %s", table)
+	}
+	if len(params) > 0 {
+		target = target + "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not %s %s: %w", method, target, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response from %s: %w", target, err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("could not %s %s: status %d: %s", method, target, resp.StatusCode, respBody)
+	}
+	if len(respBody) == 0 {
+		return nil
+	}
+
+	err = json.Unmarshal(respBody, result)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal response %s: %w", respBody, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package factory
+
+import (
+	"strings"
+	"unicode"
+)
+
+var irregularPlurals = map[string]string{
+	"person": "people",
+	"man":    "men",
+	"woman":  "women",
+	"child":  "children",
+	"tooth":  "teeth",
+	"foot":   "feet",
+	"mouse":  "mice",
+	"goose":  "geese",
+	"ox":     "oxen",
+}
+
+var uncountables = map[string]bool{
+	"sheep":       true,
+	"fish":        true,
+	"series":      true,
+	"species":     true,
+	"money":       true,
+	"information": true,
+	"equipment":   true,
+}
+
+func Pluralize(name string) string {
+	words := strings.Split(snakeCase(name), "_")
+	last := len(words) - 1
+	words[last] = pluralizeWord(words[last])
+
+	return strings.Join(words, "_")
+}
+
+func pluralizeWord(word string) string {
+	if plural, ok := irregularPlurals[word]; ok {
+		return plural
+	}
+	if uncountables[word] {
+		return word
+	}
+
+	switch {
+	case strings.HasSuffix(word, "s"), strings.HasSuffix(word, "x"), strings.HasSuffix(word, "z"),
+		strings.HasSuffix(word, "ch"), strings.HasSuffix(word, "sh"):
+		return word + "es"
+	case strings.HasSuffix(word, "y") && len(word) > 1 && !strings.ContainsRune("aeiou", rune(word[len(word)-2])):
+		return word[:len(word)-1] + "ies"
+	default:
+		return word + "s"
+	}
+}
+
+func snakeCase(name string) string {
+	var result strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) && runes[i-1] != '_' {
+				result.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		result.WriteRune(r)
+	}
+
+	return result.String()
+}
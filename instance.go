@@ -4,10 +4,29 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-
-	"github.com/Masterminds/squirrel"
+	"reflect"
+	"sort"
+	"strings"
 )
 
+type columnQuerier interface {
+	queryColumns(ctx context.Context, table string, columns []string, where map[string]interface{}) ([]map[string]interface{}, error)
+}
+
+type defaultValue struct{}
+
+var Default = defaultValue{}
+
+type rawValue struct {
+	expr string
+}
+
+// Raw inlines expr into the generated sql instead of binding it as an argument,
+// so it must never contain untrusted input.
+func Raw(expr string) rawValue {
+	return rawValue{expr: expr}
+}
+
 type Instance struct {
 	name              string
 	baseBuilder       *Builder
@@ -16,6 +35,19 @@ type Instance struct {
 	tableName         string
 	persisted         bool
 	buildOnly         bool
+	prototype         *Prototype
+	created           bool
+	associations      []association
+	// the contents before the first save, which drops Default and Raw values
+	unsavedContents map[string]interface{}
+	// lazy instances are only saved once they were referenced
+	lazy   bool
+	onSave []func(*Instance) error
+}
+
+type association struct {
+	parent   *Instance
+	fkColumn string
 }
 
 func (i *Instance) Get(attr string) interface{} {
@@ -27,6 +59,14 @@ func (i *Instance) Get(attr string) interface{} {
 	return val
 }
 
+func (i *Instance) GetCopy(attr string) interface{} {
+	return copyValue(i.Get(attr))
+}
+
+func (i *Instance) Columns() []string {
+	return sortedColumns(i.contents)
+}
+
 func (i *Instance) With(attr string, value interface{}) *Instance {
 	newContents := make(map[string]interface{}, len(i.contents))
 	for k, v := range i.contents {
@@ -34,9 +74,120 @@ func (i *Instance) With(attr string, value interface{}) *Instance {
 	}
 	newContents[attr] = value
 	i.contents = newContents
+	i.lazy = false
+	return i
+}
+
+// the value is encoded right away so it is stored as the json text a jsonb column takes
+func (i *Instance) WithJSON(attr string, v interface{}) *Instance {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("could not set %s of %s as json: %s", attr, i.name, err.Error()))
+	}
+
+	return i.With(attr, string(encoded))
+}
+
+// an insert that did nothing on a conflict leaves the existing row, which is
+// then not removed by Cleanup either
+func (i *Instance) WasInserted() bool {
+	return i.created
+}
+
+func (i *Instance) OnSave(f func(*Instance) error) *Instance {
+	i.onSave = append(i.onSave, f)
 	return i
 }
 
+func (i *Instance) runOnSave() error {
+	// Validate rolls its saves back, so nothing was saved yet
+	if i.baseBuilder.validating {
+		return nil
+	}
+
+	for _, f := range i.onSave {
+		if err := f(i); err != nil {
+			return fmt.Errorf("on save: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (i *Instance) Keep() *Instance {
+	i.lazy = false
+	return i
+}
+
+func (i *Instance) Unset(attr string) *Instance {
+	newContents := make(map[string]interface{}, len(i.contents))
+	for k, v := range i.contents {
+		if k != attr {
+			newContents[k] = v
+		}
+	}
+	i.contents = newContents
+	return i
+}
+
+func (i *Instance) Into(schema string) *Instance {
+	if i.persisted {
+		panic(fmt.Sprintf("could not move %s into %s: already persisted", i.name, schema))
+	}
+
+	table := i.tableName
+	if idx := strings.LastIndex(table, "."); idx >= 0 {
+		table = table[idx+1:]
+	}
+	i.tableName = schema + "." + table
+
+	return i
+}
+
+func (i *Instance) Embed(field string, child *Instance) *Instance {
+	childContents := make(map[string]interface{}, len(child.contents))
+	for k, v := range child.contents {
+		childContents[k] = v
+	}
+	child.buildOnly = true
+	return i.With(field, childContents)
+}
+
+func (i *Instance) BelongsTo(parent *Instance, fkColumn string) *Instance {
+	i.associations = append(i.associations, association{parent: parent, fkColumn: fkColumn})
+	i.resolveAssociations()
+	parent.Keep()
+	return i.Keep()
+}
+
+func (i *Instance) resolveAssociations() {
+	for _, a := range i.associations {
+		value, ok := a.parent.contents[a.parent.idColumn()]
+		if ok {
+			i.With(a.fkColumn, value)
+		}
+	}
+}
+
+// displayName adds the value of the DisplayNameColumn to the instance name in
+// errors, as names like users don't tell which row failed
+func (i *Instance) displayName() string {
+	column := i.baseBuilder.displayColumn
+	if column == "" {
+		return i.name
+	}
+	value, ok := i.contents[column]
+	if !ok || value == nil {
+		return i.name
+	}
+
+	return fmt.Sprintf("%s (%v)", i.name, value)
+}
+
+func (i *Instance) idColumn() string {
+	return i.baseBuilder.idColumn(i.prototype)
+}
+
 func (i *Instance) Contents() string {
 	jsonContents, err := json.Marshal(i.contents)
 	if err != nil {
@@ -45,42 +196,366 @@ func (i *Instance) Contents() string {
 	return string(jsonContents)
 }
 
-func (i *Instance) persist(save PersistFunc, placeholderFormat squirrel.PlaceholderFormat) error {
-	sql, args, err := i.insert()
+func (i *Instance) PendingUpdate() ([]string, bool) {
+	if !i.persisted {
+		return nil, false
+	}
+
+	return i.changedColumns(), true
+}
+
+func (i *Instance) changedColumns() []string {
+	columns := make([]string, 0)
+	for k, v := range i.contents {
+		persistedValue, ok := i.persistedContents[k]
+		if !ok || !i.sameValue(k, persistedValue, v) {
+			columns = append(columns, k)
+		}
+	}
+	sort.Strings(columns)
+
+	return columns
+}
+
+// sameValue reports whether a value is unchanged, using the prototype's comparator
+// for the attribute when it has one
+func (i *Instance) sameValue(attr string, old, new interface{}) bool {
+	if i.prototype != nil {
+		if equal, ok := i.prototype.DirtyComparator[attr]; ok {
+			return equal(old, new)
+		}
+	}
+
+	return reflect.DeepEqual(old, new)
+}
+
+func (i *Instance) Create(ctx context.Context) error {
 	if i.persisted {
-		sql, args, err = i.update()
+		return fmt.Errorf("could not create %s: already persisted", i.displayName())
+	}
+
+	if err := i.persist(ctx, i.baseBuilder.persister); err != nil {
+		return fmt.Errorf("could not create %s: %w", i.displayName(), err)
+	}
+
+	if i.prototype == nil || i.prototype.PrimaryKey == "" {
+		return nil
+	}
+
+	return i.Reload(ctx)
+}
+
+func (i *Instance) Reload(ctx context.Context) error {
+	if !i.persisted {
+		return fmt.Errorf("could not reload %s: not persisted", i.name)
 	}
+
+	where := i.updateWhere()
+	rows, err := i.baseBuilder.persister.Query(ctx, i.tableName, where)
 	if err != nil {
-		return fmt.Errorf("could not build sql: %w", err)
+		return fmt.Errorf("could not reload %s: %w", i.name, err)
+	}
+	if len(rows) != 1 {
+		return fmt.Errorf("could not reload %s: expected 1 row, found %d", i.name, len(rows))
 	}
 
-	if err := save(context.Background(), sql, args...); err != nil {
-		return fmt.Errorf("could not persist: %w", err)
+	contents := i.prototype.fromColumns(rows[0])
+	i.contents = contents
+	i.persistedContents = contents
+
+	return nil
+}
+
+func (i *Instance) RefreshColumn(ctx context.Context, column string) error {
+	if !i.persisted {
+		return fmt.Errorf("could not refresh %s of %s: not persisted", column, i.name)
+	}
+	if i.prototype == nil || i.prototype.PrimaryKey == "" {
+		return fmt.Errorf("could not refresh %s of %s: no primary key", column, i.name)
+	}
+	if !identifierRegex.MatchString(column) {
+		return fmt.Errorf("could not refresh %s of %s: invalid column name %q", column, i.name, column)
 	}
 
-	i.persisted = true
-	i.persistedContents = i.contents
+	var (
+		rows []map[string]interface{}
+		err  error
+	)
+	persister := i.baseBuilder.persister
+	if q, ok := persister.(columnQuerier); ok {
+		rows, err = q.queryColumns(ctx, i.tableName, []string{i.prototype.column(column)}, i.updateWhere())
+	} else {
+		rows, err = persister.Query(ctx, i.tableName, i.updateWhere())
+	}
+	if err != nil {
+		return fmt.Errorf("could not refresh %s of %s: %w", column, i.name, err)
+	}
+	if len(rows) != 1 {
+		return fmt.Errorf("could not refresh %s of %s: expected 1 row, found %d", column, i.name, len(rows))
+	}
+
+	value, ok := rows[0][i.prototype.column(column)]
+	if !ok {
+		return fmt.Errorf("could not refresh %s of %s: column not returned", column, i.name)
+	}
+	i.With(column, value)
+
+	persistedContents := make(map[string]interface{}, len(i.persistedContents)+1)
+	for k, v := range i.persistedContents {
+		persistedContents[k] = v
+	}
+	persistedContents[column] = value
+	i.persistedContents = persistedContents
+
+	return nil
+}
+
+func (i *Instance) remove(ctx context.Context, persister Persister) error {
+	if err := persister.Delete(ctx, i.tableName, i.updateWhere()); err != nil {
+		return fmt.Errorf("could not delete: %w", err)
+	}
+
+	i.persisted = false
+	i.created = false
+	i.persistedContents = nil
+	i.baseBuilder.invalidateFinds(i.tableName)
 
 	return nil
 }
 
-func (i *Instance) insert() (string, []interface{}, error) {
-	var keys []string
-	var values []interface{}
+func (i *Instance) writableContents() map[string]interface{} {
+	if i.prototype == nil || len(i.prototype.ReadOnlyColumns) == 0 {
+		return i.contents
+	}
+
+	row := make(map[string]interface{}, len(i.contents))
 	for k, v := range i.contents {
-		keys = append(keys, k)
-		values = append(values, v)
+		row[k] = v
+	}
+	for _, column := range i.prototype.ReadOnlyColumns {
+		delete(row, column)
+	}
+
+	return row
+}
+
+func (i *Instance) persistContents() (map[string]interface{}, error) {
+	hook := i.baseBuilder.beforePersist
+	if hook == nil {
+		return i.writableContents(), nil
+	}
+
+	row := make(map[string]interface{}, len(i.contents))
+	for k, v := range i.writableContents() {
+		row[k] = v
+	}
+	if err := hook(i.tableName, row); err != nil {
+		return nil, fmt.Errorf("before persist: %w", err)
+	}
+
+	return row, nil
+}
+
+// updates only write the columns PendingUpdate reports, along with anything
+// the BeforePersist hook added or changed
+func (i *Instance) updateContents() (map[string]interface{}, error) {
+	row, err := i.persistContents()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]bool)
+	for _, column := range i.changedColumns() {
+		changed[column] = true
+	}
+	set := make(map[string]interface{}, len(changed))
+	for k, v := range row {
+		current, ok := i.contents[k]
+		if changed[k] || !ok || !reflect.DeepEqual(v, current) {
+			set[k] = v
+		}
+	}
+
+	return set, nil
+}
+
+func (i *Instance) insertContents() (map[string]interface{}, error) {
+	row, err := i.persistContents()
+	if err != nil {
+		return nil, err
+	}
+	if i.prototype == nil || !i.prototype.StrictColumns {
+		return i.prototype.toColumns(i.baseBuilder.applyNilStrategy(row)), nil
+	}
+
+	columns, err := outlineColumns(i.prototype.Outline)
+	if err != nil {
+		return nil, fmt.Errorf("could not read prototype columns: %w", err)
+	}
+
+	strict := make(map[string]interface{}, len(columns))
+	for k, v := range row {
+		strict[k] = v
+	}
+	for _, column := range columns {
+		if _, ok := strict[column]; !ok && !i.isReadOnly(column) {
+			strict[column] = nil
+		}
+	}
+
+	return i.prototype.toColumns(i.baseBuilder.applyNilStrategy(strict)), nil
+}
+
+func (i *Instance) InsertPlan() (string, []string, []interface{}, error) {
+	if i.persisted {
+		return "", nil, nil, fmt.Errorf("could not plan insert of %s: already persisted", i.name)
+	}
+
+	i.resolveAssociations()
+	row, err := i.insertContents()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("could not plan insert of %s: %w", i.name, err)
+	}
+
+	columns := orderedColumns(withColumnOrder(context.Background(), i.prototype), row)
+	values := make([]interface{}, len(columns))
+	for idx, column := range columns {
+		values[idx] = row[column]
+	}
+
+	return i.tableName, columns, values, nil
+}
+
+// tables like views can't be written to, so saving their instances fails before any sql is run
+func (i *Instance) checkWritable() error {
+	if i.prototype != nil && i.prototype.ReadOnly {
+		return fmt.Errorf("could not persist: %s is read only", i.tableName)
 	}
 
-	return squirrel.Insert(i.tableName).Columns(keys...).Values(values...).PlaceholderFormat(i.baseBuilder.placeholderFormat).ToSql()
+	return nil
+}
+
+func (i *Instance) isReadOnly(column string) bool {
+	for _, readOnly := range i.prototype.ReadOnlyColumns {
+		if readOnly == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (i *Instance) updateWhere() map[string]interface{} {
+	if i.prototype == nil || i.prototype.PrimaryKey == "" {
+		return i.prototype.toColumns(i.storedWhere())
+	}
+
+	key := i.prototype.PrimaryKey
+	return map[string]interface{}{i.prototype.column(key): i.persistedContents[key]}
 }
 
-func (i *Instance) update() (string, []interface{}, error) {
-	builder := squirrel.Update(i.tableName).SetMap(i.contents)
+// empty strings were written as nil, so the row is matched the same way: with
+// IS NULL, or not at all when the nil strategy left the value to the database
+func (i *Instance) storedWhere() map[string]interface{} {
+	b := i.baseBuilder
+	if !b.emptyStringAsNull {
+		return i.persistedContents
+	}
 
+	where := make(map[string]interface{}, len(i.persistedContents))
 	for k, v := range i.persistedContents {
-		builder = builder.Where(squirrel.Eq{k: v})
+		if s, ok := v.(string); ok && s == "" {
+			if b.nilStrategy != NilNull {
+				continue
+			}
+			v = nil
+		}
+		where[k] = v
+	}
+
+	return where
+}
+
+func (i *Instance) persist(ctx context.Context, persister Persister) error {
+	var (
+		returned map[string]interface{}
+		err      error
+	)
+	if err := i.checkWritable(); err != nil {
+		return err
+	}
+	i.resolveAssociations()
+
+	ctx = withColumnCasts(withColumnOrder(ctx, i.prototype), i.prototype)
+	if i.persisted {
+		var set map[string]interface{}
+		set, err = i.updateContents()
+		if err == nil {
+			set = i.baseBuilder.applyNilStrategy(set)
+			// the nil strategy can leave nothing to write, like an omitted nil
+			if len(set) > 0 {
+				err = persister.Update(ctx, i.tableName, i.prototype.toColumns(set), i.updateWhere())
+			}
+		}
+	} else {
+		var row map[string]interface{}
+		row, err = i.insertContents()
+		if err == nil {
+			returned, err = persister.Insert(withReturning(withUpsert(ctx, i.prototype), i.prototype), i.tableName, row)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not persist: %w", err)
+	}
+
+	i.markPersisted(returned)
+
+	return i.runOnSave()
+}
+
+func (i *Instance) markPersisted(returned map[string]interface{}) {
+	if !i.persisted {
+		i.unsavedContents = i.contents
+	}
+	returned = i.prototype.fromColumns(returned)
+	_, skipped := returned[notInsertedKey]
+	delete(returned, notInsertedKey)
+	if id, ok := returned[lastInsertIDKey]; ok {
+		delete(returned, lastInsertIDKey)
+		if i.prototype != nil && i.prototype.AutoIncrementColumn != "" {
+			returned[i.prototype.AutoIncrementColumn] = id
+		}
+	}
+	for k, v := range returned {
+		i.With(k, v)
+	}
+
+	if !i.persisted && !skipped {
+		i.created = true
+	}
+
+	i.persisted = true
+	i.contents = i.storedContents()
+	i.persistedContents = i.contents
+	i.baseBuilder.invalidateFinds(i.tableName)
+}
+
+// the values stored for Default and Raw, and for nils the nil strategy omitted or
+// wrote as DEFAULT, are only known to the database. they are dropped once saved
+// instead of being compared in WHEREs or written again by updates
+func (i *Instance) storedContents() map[string]interface{} {
+	stored := make(map[string]interface{}, len(i.contents))
+	for k, v := range i.contents {
+		switch v.(type) {
+		case defaultValue, rawValue:
+			continue
+		case nil:
+			if i.baseBuilder.nilStrategy != NilNull {
+				continue
+			}
+		}
+		stored[k] = v
 	}
 
-	return builder.PlaceholderFormat(i.baseBuilder.placeholderFormat).ToSql()
+	return stored
 }
@@ -0,0 +1,195 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errRawNotSupported = errors.New("raw sql expressions are not supported by the memory persister")
+
+type MemoryPersister struct {
+	mu     sync.Mutex
+	tables map[string][]map[string]interface{}
+}
+
+func NewMemoryPersister() *MemoryPersister {
+	return &MemoryPersister{tables: make(map[string][]map[string]interface{})}
+}
+
+func (p *MemoryPersister) Insert(ctx context.Context, table string, row map[string]interface{}) (map[string]interface{}, error) {
+	stored, err := memoryRow(row)
+	if err != nil {
+		return nil, fmt.Errorf("could not insert into %s: %w", table, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tables[table] = append(p.tables[table], stored)
+
+	return nil, nil
+}
+
+func (p *MemoryPersister) Update(ctx context.Context, table string, set, where map[string]interface{}) error {
+	values, err := memoryRow(set)
+	if err != nil {
+		return fmt.Errorf("could not update %s: %w", table, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, row := range p.tables[table] {
+		matched, err := memoryMatch(row, where)
+		if err != nil {
+			return fmt.Errorf("could not update %s: %w", table, err)
+		}
+		if !matched {
+			continue
+		}
+		for k, v := range values {
+			row[k] = v
+		}
+	}
+
+	return nil
+}
+
+func (p *MemoryPersister) Delete(ctx context.Context, table string, where map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	kept := make([]map[string]interface{}, 0, len(p.tables[table]))
+	for _, row := range p.tables[table] {
+		matched, err := memoryMatch(row, where)
+		if err != nil {
+			return fmt.Errorf("could not delete from %s: %w", table, err)
+		}
+		if !matched {
+			kept = append(kept, row)
+		}
+	}
+	p.tables[table] = kept
+
+	return nil
+}
+
+func (p *MemoryPersister) Query(ctx context.Context, table string, where map[string]interface{}) ([]map[string]interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rows := make([]map[string]interface{}, 0)
+	for _, row := range p.tables[table] {
+		matched, err := memoryMatch(row, where)
+		if err != nil {
+			return nil, fmt.Errorf("could not query %s: %w", table, err)
+		}
+		if matched {
+			rows = append(rows, copyRow(row))
+		}
+	}
+
+	return rows, nil
+}
+
+func (p *MemoryPersister) Rows(table string) []map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rows := make([]map[string]interface{}, len(p.tables[table]))
+	for idx, row := range p.tables[table] {
+		rows[idx] = copyRow(row)
+	}
+
+	return rows
+}
+
+func (p *MemoryPersister) truncate(ctx context.Context, tables []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, table := range tables {
+		delete(p.tables, table)
+	}
+
+	return nil
+}
+
+// columns set to Default are left out, as there is no schema to take the default from
+func memoryRow(row map[string]interface{}) (map[string]interface{}, error) {
+	stored := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		switch v.(type) {
+		case defaultValue:
+			continue
+		case rawValue:
+			return nil, fmt.Errorf("%s: %w", k, errRawNotSupported)
+		}
+		stored[k] = v
+	}
+
+	return stored, nil
+}
+
+// memoryMatch follows the sql persister: keys are compared for equality, lists
+// match any of their values, and $and/$or group nested conditions
+func memoryMatch(row, where map[string]interface{}) (bool, error) {
+	for k, v := range where {
+		if k == andOperator || k == orOperator {
+			list, ok := v.([]interface{})
+			if !ok {
+				return false, fmt.Errorf("%s must be a list of conditions, got %T", k, v)
+			}
+
+			anyMatched := false
+			for _, item := range list {
+				condition, ok := item.(map[string]interface{})
+				if !ok {
+					return false, fmt.Errorf("%s must be a list of conditions, got %T", k, item)
+				}
+				matched, err := memoryMatch(row, condition)
+				if err != nil {
+					return false, err
+				}
+				if matched && k == orOperator {
+					anyMatched = true
+					break
+				}
+				if !matched && k == andOperator {
+					return false, nil
+				}
+			}
+			if k == orOperator && !anyMatched {
+				return false, nil
+			}
+			continue
+		}
+
+		if !memoryValueMatch(row[k], v) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func memoryValueMatch(stored, expected interface{}) bool {
+	if values, ok := expected.([]interface{}); ok {
+		for _, value := range values {
+			if valuesEqual(stored, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return valuesEqual(stored, expected)
+}
+
+func copyRow(row map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		copied[k] = v
+	}
+
+	return copied
+}
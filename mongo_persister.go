@@ -0,0 +1,56 @@
+//go:build mongo
+
+package factory
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MongoPersister struct {
+	db *mongo.Database
+}
+
+func NewMongoPersister(db *mongo.Database) *MongoPersister {
+	return &MongoPersister{db: db}
+}
+
+func (p *MongoPersister) Insert(ctx context.Context, table string, row map[string]interface{}) (map[string]interface{}, error) {
+	result, err := p.db.Collection(table).InsertOne(ctx, bson.M(row))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"_id": result.InsertedID}, nil
+}
+
+func (p *MongoPersister) Update(ctx context.Context, table string, set, where map[string]interface{}) error {
+	_, err := p.db.Collection(table).UpdateMany(ctx, bson.M(where), bson.M{"$set": bson.M(set)})
+	return err
+}
+
+func (p *MongoPersister) Delete(ctx context.Context, table string, where map[string]interface{}) error {
+	_, err := p.db.Collection(table).DeleteMany(ctx, bson.M(where))
+	return err
+}
+
+func (p *MongoPersister) Query(ctx context.Context, table string, where map[string]interface{}) ([]map[string]interface{}, error) {
+	cursor, err := p.db.Collection(table).Find(ctx, bson.M(where))
+	if err != nil {
+		return nil, err
+	}
+
+	var documents []bson.M
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	rows := make([]map[string]interface{}, 0, len(documents))
+	for _, d := range documents {
+		rows = append(rows, map[string]interface{}(d))
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,32 @@
+package factory
+
+type NilStrategy int
+
+const (
+	NilNull NilStrategy = iota
+	NilOmit
+	NilDefault
+)
+
+// empty strings are turned into nil first, so they follow the nil strategy too
+func (b *Builder) applyNilStrategy(row map[string]interface{}) map[string]interface{} {
+	if b.nilStrategy == NilNull && !b.emptyStringAsNull {
+		return row
+	}
+
+	applied := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		if s, ok := v.(string); ok && s == "" && b.emptyStringAsNull {
+			v = nil
+		}
+		if v != nil || b.nilStrategy == NilNull {
+			applied[k] = v
+			continue
+		}
+		if b.nilStrategy == NilDefault {
+			applied[k] = Default
+		}
+	}
+
+	return applied
+}
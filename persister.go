@@ -0,0 +1,529 @@
+package factory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+const (
+	lastInsertIDKey = "$lastInsertId"
+	notInsertedKey  = "$notInserted"
+	andOperator     = "$and"
+	orOperator      = "$or"
+)
+
+var identifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.]*$`)
+
+type Persister interface {
+	Insert(ctx context.Context, table string, row map[string]interface{}) (map[string]interface{}, error)
+	Update(ctx context.Context, table string, set, where map[string]interface{}) error
+	Delete(ctx context.Context, table string, where map[string]interface{}) error
+	Query(ctx context.Context, table string, where map[string]interface{}) ([]map[string]interface{}, error)
+}
+
+type StatementError struct {
+	SQL  string
+	Args []interface{}
+	Err  error
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("%s (sql: %s, args: %v)", e.Err.Error(), e.SQL, e.Args)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+type OpKind int
+
+const (
+	OpInsert OpKind = iota
+	OpUpdate
+	OpDelete
+	OpSelect
+)
+
+type SQLPersister struct {
+	persistFunc       PersistFunc
+	persistResultFunc PersistResultFunc
+	queryFunc         QueryFunc
+	queryRowsFunc     QueryRowsFunc
+	placeholderFormat squirrel.PlaceholderFormat
+	sqlRewrite        func(op OpKind, sql string) string
+	schema            string
+	orderBy           string
+	// overrides the quote picked from the placeholder format
+	identifierQuote string
+}
+
+func NewSQLPersister(persistFunc PersistFunc, queryFunc QueryFunc, placeholderFormat squirrel.PlaceholderFormat) *SQLPersister {
+	if placeholderFormat == nil {
+		placeholderFormat = squirrel.Question
+	}
+
+	return &SQLPersister{
+		persistFunc:       persistFunc,
+		queryFunc:         queryFunc,
+		placeholderFormat: placeholderFormat,
+	}
+}
+
+// with a schema configured, tables are qualified and quoted so each tenant's
+// schema can be named freely; tables that already name a schema keep it
+func (p *SQLPersister) table(table string) string {
+	if p.schema == "" {
+		return table
+	}
+
+	parts := strings.Split(table, ".")
+	if len(parts) == 1 {
+		parts = []string{p.schema, table}
+	}
+	quote := p.quote()
+	for idx, part := range parts {
+		parts[idx] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// mysql reads double quotes as strings unless ANSI_QUOTES is on
+func (p *SQLPersister) quote() string {
+	if p.identifierQuote != "" {
+		return p.identifierQuote
+	}
+	if p.placeholderFormat != squirrel.Dollar {
+		return "`"
+	}
+
+	return `"`
+}
+
+func (p *SQLPersister) rewrite(op OpKind, sql string) string {
+	if p.sqlRewrite == nil {
+		return sql
+	}
+
+	return p.sqlRewrite(op, sql)
+}
+
+func (p *SQLPersister) Insert(ctx context.Context, table string, row map[string]interface{}) (map[string]interface{}, error) {
+	insertBuilder, err := p.insertBuilder(ctx, table, row)
+	if err != nil {
+		return nil, err
+	}
+
+	canReturn := p.queryRowsFunc != nil || p.queryFunc != nil
+	returning := returningColumns(ctx)
+	suffix, isUpsert := upsertSuffix(ctx, orderedColumns(ctx, row), p.placeholderFormat)
+	if isUpsert {
+		insertBuilder = insertBuilder.Suffix(suffix)
+		if len(returning) == 0 && p.placeholderFormat == squirrel.Dollar {
+			returning = []string{"*"}
+		}
+	}
+	if len(returning) > 0 && canReturn {
+		rows, err := p.insertReturning(ctx, insertBuilder, returning)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			// only an insert that does nothing on a conflict returns no row
+			if ignoresConflicts(ctx) {
+				return map[string]interface{}{notInsertedKey: true}, nil
+			}
+			return nil, nil
+		}
+		return rows[0], nil
+	}
+
+	sql, args, err := insertBuilder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpInsert, sql)
+
+	if p.persistResultFunc == nil {
+		return nil, p.exec(ctx, sql, args...)
+	}
+
+	result, err := p.persistResultFunc(ctx, sql, args...)
+	if err != nil {
+		return nil, &StatementError{SQL: sql, Args: args, Err: err}
+	}
+	if ignoresConflicts(ctx) {
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			return map[string]interface{}{notInsertedKey: true}, nil
+		}
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, nil
+	}
+
+	return map[string]interface{}{lastInsertIDKey: id}, nil
+}
+
+func (p *SQLPersister) insertReturning(ctx context.Context, insertBuilder squirrel.InsertBuilder, columns []string) ([]map[string]interface{}, error) {
+	for _, column := range columns {
+		if column != "*" && !identifierRegex.MatchString(column) {
+			return nil, fmt.Errorf("invalid column name %q", column)
+		}
+	}
+
+	sql, args, err := insertBuilder.Suffix("RETURNING " + strings.Join(columns, ", ")).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpInsert, sql)
+
+	return p.queryRows(ctx, sql, args...)
+}
+
+// postgres returns the inserted row, otherwise whether the row was inserted can
+// only be told from the affected rows
+func (p *SQLPersister) canInsertIgnore() bool {
+	return p.persistResultFunc != nil || (p.placeholderFormat == squirrel.Dollar && (p.queryRowsFunc != nil || p.queryFunc != nil))
+}
+
+func (p *SQLPersister) insertIgnore(ctx context.Context, table string, row map[string]interface{}) (map[string]interface{}, bool, error) {
+	insertBuilder, err := p.insertBuilder(ctx, table, row)
+	if err != nil {
+		return nil, false, err
+	}
+
+	postgres := p.placeholderFormat == squirrel.Dollar
+	if !postgres || (p.queryRowsFunc == nil && p.queryFunc == nil) {
+		return p.insertIgnoreAffected(ctx, insertBuilder, postgres)
+	}
+
+	sql, args, err := insertBuilder.Suffix("ON CONFLICT DO NOTHING RETURNING *").ToSql()
+	if err != nil {
+		return nil, false, fmt.Errorf("could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpInsert, sql)
+
+	rows, err := p.queryRows(ctx, sql, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(rows) == 0 {
+		return nil, false, nil
+	}
+
+	return rows[0], true, nil
+}
+
+// mysql has no ON CONFLICT, INSERT IGNORE skips rows that conflict with a unique key instead
+func (p *SQLPersister) insertIgnoreAffected(ctx context.Context, insertBuilder squirrel.InsertBuilder, postgres bool) (map[string]interface{}, bool, error) {
+	if postgres {
+		insertBuilder = insertBuilder.Suffix("ON CONFLICT DO NOTHING")
+	} else {
+		insertBuilder = insertBuilder.Options("IGNORE")
+	}
+
+	sql, args, err := insertBuilder.ToSql()
+	if err != nil {
+		return nil, false, fmt.Errorf("could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpInsert, sql)
+
+	result, err := p.persistResultFunc(ctx, sql, args...)
+	if err != nil {
+		return nil, false, &StatementError{SQL: sql, Args: args, Err: err}
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, fmt.Errorf("could not read affected rows: %w", err)
+	}
+	if affected == 0 {
+		return nil, false, nil
+	}
+
+	if id, err := result.LastInsertId(); err == nil {
+		return map[string]interface{}{lastInsertIDKey: id}, true, nil
+	}
+	return nil, true, nil
+}
+
+func (p *SQLPersister) insertBuilder(ctx context.Context, table string, row map[string]interface{}) (squirrel.InsertBuilder, error) {
+	if err := validateColumns(row); err != nil {
+		return squirrel.InsertBuilder{}, err
+	}
+
+	keys := orderedColumns(ctx, row)
+	values := make([]interface{}, len(keys))
+	for idx, k := range keys {
+		value, err := sqlValue(row[k])
+		if err != nil {
+			return squirrel.InsertBuilder{}, fmt.Errorf("could not encode %s: %w", k, err)
+		}
+		values[idx] = castValue(ctx, k, value)
+	}
+
+	return squirrel.Insert(p.table(table)).Columns(keys...).Values(values...).PlaceholderFormat(p.placeholderFormat), nil
+}
+
+func (p *SQLPersister) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) ([]map[string]interface{}, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	if err := validateColumns(rows...); err != nil {
+		return nil, err
+	}
+
+	columns := orderedColumns(ctx, rows[0])
+	builder := squirrel.Insert(p.table(table)).Columns(columns...)
+	for _, row := range rows {
+		if len(row) != len(columns) {
+			return nil, fmt.Errorf("could not batch insert into %s: rows have different columns", table)
+		}
+
+		values := make([]interface{}, len(columns))
+		for idx, column := range columns {
+			v, ok := row[column]
+			if !ok {
+				return nil, fmt.Errorf("could not batch insert into %s: rows have different columns", table)
+			}
+			value, err := sqlValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("could not encode %s: %w", column, err)
+			}
+			values[idx] = castValue(ctx, column, value)
+		}
+		builder = builder.Values(values...)
+	}
+
+	builder = builder.PlaceholderFormat(p.placeholderFormat)
+	// the returned rows are matched back to their instances by insertBatches
+	if returning := returningColumns(ctx); len(returning) > 0 && (p.queryRowsFunc != nil || p.queryFunc != nil) {
+		returned, err := p.insertReturning(ctx, builder, returning)
+		if err != nil {
+			return nil, err
+		}
+		if len(returned) != len(rows) {
+			return nil, fmt.Errorf("could not batch insert into %s: inserted %d rows but %d were returned", table, len(rows), len(returned))
+		}
+		return returned, nil
+	}
+
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpInsert, sql)
+
+	return nil, p.exec(ctx, sql, args...)
+}
+
+func (p *SQLPersister) Update(ctx context.Context, table string, set, where map[string]interface{}) error {
+	if err := validateColumns(set, where); err != nil {
+		return err
+	}
+
+	builder := squirrel.Update(p.table(table))
+	for _, k := range orderedColumns(ctx, set) {
+		value, err := sqlValue(set[k])
+		if err != nil {
+			return fmt.Errorf("could not encode %s: %w", k, err)
+		}
+		builder = builder.Set(k, castValue(ctx, k, value))
+	}
+
+	for _, k := range sortedColumns(where) {
+		v := where[k]
+		value, err := sqlValue(v)
+		if err != nil {
+			return fmt.Errorf("could not encode %s: %w", k, err)
+		}
+		builder = builder.Where(squirrel.Eq{k: value})
+	}
+
+	sql, args, err := builder.PlaceholderFormat(p.placeholderFormat).ToSql()
+	if err != nil {
+		return fmt.Errorf("could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpUpdate, sql)
+
+	return p.exec(ctx, sql, args...)
+}
+
+func (p *SQLPersister) Delete(ctx context.Context, table string, where map[string]interface{}) error {
+	if err := validateColumns(where); err != nil {
+		return err
+	}
+
+	builder := squirrel.Delete(p.table(table))
+
+	for _, k := range sortedColumns(where) {
+		value, err := sqlValue(where[k])
+		if err != nil {
+			return fmt.Errorf("could not encode %s: %w", k, err)
+		}
+		builder = builder.Where(squirrel.Eq{k: value})
+	}
+
+	sql, args, err := builder.PlaceholderFormat(p.placeholderFormat).ToSql()
+	if err != nil {
+		return fmt.Errorf("could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpDelete, sql)
+
+	return p.exec(ctx, sql, args...)
+}
+
+func (p *SQLPersister) Query(ctx context.Context, table string, where map[string]interface{}) ([]map[string]interface{}, error) {
+	return p.selectRows(ctx, table, []string{"*"}, where, p.orderBy)
+}
+
+func (p *SQLPersister) queryColumns(ctx context.Context, table string, columns []string, where map[string]interface{}) ([]map[string]interface{}, error) {
+	return p.selectRows(ctx, table, columns, where, "")
+}
+
+// only selects of whole rows are ordered, as selected columns may be aggregates
+func (p *SQLPersister) selectRows(ctx context.Context, table string, columns []string, where map[string]interface{}, orderBy string) ([]map[string]interface{}, error) {
+	condition, err := whereCondition(where)
+	if err != nil {
+		return nil, err
+	}
+
+	selectBuilder := squirrel.Select(columns...).From(p.table(table))
+	if len(where) > 0 {
+		selectBuilder = selectBuilder.Where(condition)
+	}
+	if orderBy != "" {
+		selectBuilder = selectBuilder.OrderBy(orderBy)
+	}
+
+	sql, args, err := selectBuilder.PlaceholderFormat(p.placeholderFormat).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpSelect, sql)
+
+	return p.queryRows(ctx, sql, args...)
+}
+
+func whereCondition(where map[string]interface{}) (squirrel.Sqlizer, error) {
+	eq := squirrel.Eq{}
+	var groups []squirrel.Sqlizer
+
+	for _, k := range sortedColumns(where) {
+		if k != andOperator && k != orOperator {
+			if !identifierRegex.MatchString(k) {
+				return nil, fmt.Errorf("invalid column name %q", k)
+			}
+			eq[k] = where[k]
+			continue
+		}
+
+		list, ok := where[k].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of conditions, got %T", k, where[k])
+		}
+
+		conditions := make([]squirrel.Sqlizer, 0, len(list))
+		for _, item := range list {
+			nested, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of conditions, got %T in list", k, item)
+			}
+			condition, err := whereCondition(nested)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, condition)
+		}
+
+		if k == andOperator {
+			groups = append(groups, squirrel.And(conditions))
+		} else {
+			groups = append(groups, squirrel.Or(conditions))
+		}
+	}
+
+	if len(groups) == 0 {
+		return eq, nil
+	}
+	if len(eq) > 0 {
+		groups = append([]squirrel.Sqlizer{eq}, groups...)
+	}
+	if len(groups) == 1 {
+		return groups[0], nil
+	}
+
+	return squirrel.And(groups), nil
+}
+
+func (p *SQLPersister) queryRows(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	if p.queryRowsFunc != nil {
+		rows, err := p.queryRowsFunc(ctx, sql, args...)
+		if err != nil {
+			return nil, &StatementError{SQL: sql, Args: args, Err: err}
+		}
+		return rows, nil
+	}
+
+	result, err := p.queryFunc(ctx, sql, args...)
+	if err != nil {
+		return nil, &StatementError{SQL: sql, Args: args, Err: err}
+	}
+
+	var rows []map[string]interface{}
+	err = json.Unmarshal([]byte(result), &rows)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal query result %s: %w", result, err)
+	}
+
+	return rows, nil
+}
+
+func sqlValue(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case defaultValue:
+		return squirrel.Expr("DEFAULT"), nil
+	case rawValue:
+		return squirrel.Expr(v.(rawValue).expr), nil
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(encoded), nil
+	default:
+		return v, nil
+	}
+}
+
+func (p *SQLPersister) exec(ctx context.Context, sql string, args ...interface{}) error {
+	var err error
+	if p.persistResultFunc == nil {
+		err = p.persistFunc(ctx, sql, args...)
+	} else {
+		_, err = p.persistResultFunc(ctx, sql, args...)
+	}
+	if err != nil {
+		return &StatementError{SQL: sql, Args: args, Err: err}
+	}
+
+	return nil
+}
+
+func validateColumns(rows ...map[string]interface{}) error {
+	for _, row := range rows {
+		for column := range row {
+			if !identifierRegex.MatchString(column) {
+				return fmt.Errorf("invalid column name %q", column)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package factory
+
+type saveAction int
+
+const (
+	saveSkip saveAction = iota
+	saveInsert
+	saveUpdate
+)
+
+type SavePlan struct {
+	Inserts []*Instance
+	Updates []*Instance
+	Skips   []*Instance
+}
+
+func (b *Builder) Plan() SavePlan {
+	instances, err := b.saveOrder()
+	if err != nil {
+		instances = b.instances
+	}
+
+	var plan SavePlan
+	for _, instance := range instances {
+		switch instance.saveAction() {
+		case saveInsert:
+			plan.Inserts = append(plan.Inserts, instance)
+		case saveUpdate:
+			plan.Updates = append(plan.Updates, instance)
+		default:
+			plan.Skips = append(plan.Skips, instance)
+		}
+	}
+
+	return plan
+}
+
+func (i *Instance) saveAction() saveAction {
+	if i.buildOnly || i.lazy {
+		return saveSkip
+	}
+	if !i.persisted {
+		return saveInsert
+	}
+
+	i.resolveAssociations()
+	if len(i.changedColumns()) == 0 {
+		return saveSkip
+	}
+
+	return saveUpdate
+}
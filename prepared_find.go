@@ -0,0 +1,128 @@
+package factory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type PreparedFind struct {
+	builder      *Builder
+	table        string
+	query        string
+	columns      []string
+	values       []interface{}
+	sql          string
+	instanceName []string
+}
+
+func (b *Builder) PrepareFind(table, query string, instanceName ...string) *PreparedFind {
+	var queryMap map[string]interface{}
+	err := json.Unmarshal([]byte(query), &queryMap)
+	if err != nil {
+		panic(fmt.Sprintf("could not build query: json error: %s: %s", err.Error(), query))
+	}
+	if err := validateColumns(queryMap); err != nil {
+		panic(fmt.Sprintf("could not prepare %s from %s: %s", query, table, err.Error()))
+	}
+
+	columns := make([]string, 0, len(queryMap))
+	for k := range queryMap {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+
+	values := make([]interface{}, len(columns))
+	for idx, column := range columns {
+		values[idx] = queryMap[column]
+	}
+
+	prepared := &PreparedFind{
+		builder:      b,
+		table:        table,
+		query:        query,
+		columns:      columns,
+		values:       values,
+		instanceName: instanceName,
+	}
+
+	if p, ok := b.persister.(*SQLPersister); ok && !needsDynamicSQL(values) {
+		prepared.sql, _, err = prepared.selectSQL(p)
+		if err != nil {
+			panic(fmt.Sprintf("could not prepare %s from %s: %s", query, table, err.Error()))
+		}
+	}
+
+	return prepared
+}
+
+func (f *PreparedFind) Execute(args ...interface{}) []*Instance {
+	if len(args) > 0 && len(args) != len(f.columns) {
+		panic(fmt.Sprintf("could not execute %s from %s: expected %d args, got %d", f.query, f.table, len(f.columns), len(args)))
+	}
+	values := f.values
+	if len(args) > 0 {
+		values = args
+	}
+
+	var (
+		rows []map[string]interface{}
+		err  error
+	)
+	if p, ok := f.builder.persister.(*SQLPersister); ok && f.sql != "" && !needsDynamicSQL(values) {
+		rows, err = p.queryRows(context.Background(), f.sql, values...)
+	} else {
+		where := make(map[string]interface{}, len(f.columns))
+		for idx, column := range f.columns {
+			where[column] = values[idx]
+		}
+		rows, err = f.builder.persister.Query(context.Background(), f.table, where)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("could not query %s from %s: %s", f.query, f.table, err.Error()))
+	}
+
+	instances, err := f.builder.instancesFromRows(f.table, rows, f.instanceName...)
+	if err != nil {
+		panic(fmt.Sprintf("could not query %s from %s: %s", f.query, f.table, err.Error()))
+	}
+	f.builder.instances = append(f.builder.instances, instances...)
+	return instances
+}
+
+func (f *PreparedFind) selectSQL(p *SQLPersister) (string, []interface{}, error) {
+	selectBuilder := squirrel.Select("*").From(p.table(f.table))
+	for _, column := range f.columns {
+		selectBuilder = selectBuilder.Where(squirrel.Expr(column+" = ?", nil))
+	}
+	if p.orderBy != "" {
+		selectBuilder = selectBuilder.OrderBy(p.orderBy)
+	}
+
+	sql, args, err := selectBuilder.PlaceholderFormat(p.placeholderFormat).ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return p.rewrite(OpSelect, sql), args, nil
+}
+
+// nil and list values change the shape of the generated where clause (IS NULL,
+// IN (...)), so they can't be bound to the prepared statement
+func needsDynamicSQL(values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+		kind := reflect.TypeOf(v).Kind()
+		if kind == reflect.Slice || kind == reflect.Array || kind == reflect.Map {
+			return true
+		}
+	}
+
+	return false
+}
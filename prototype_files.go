@@ -0,0 +1,44 @@
+package factory
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// the file name without its extension names the prototype, so the table is
+// inferred from it like for prototypes loaded with only a Name
+func (b *Builder) LoadPrototypeFile(fsys fs.FS, file string) error {
+	outline, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return fmt.Errorf("could not load prototype from %s: %w", file, err)
+	}
+
+	name := strings.TrimSuffix(path.Base(file), path.Ext(file))
+	if err := b.LoadPrototypeE(Prototype{Name: &name, Outline: string(outline)}); err != nil {
+		return fmt.Errorf("could not load prototype from %s: %w", file, err)
+	}
+
+	return nil
+}
+
+func (b *Builder) LoadPrototypesFromFS(fsys fs.FS, glob string) error {
+	if _, err := path.Match(glob, ""); err != nil {
+		return fmt.Errorf("could not load prototypes matching %s: %w", glob, err)
+	}
+
+	return fs.WalkDir(fsys, ".", func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("could not load prototypes matching %s: %w", glob, err)
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if matched, _ := path.Match(glob, file); !matched {
+			return nil
+		}
+
+		return b.LoadPrototypeFile(fsys, file)
+	})
+}
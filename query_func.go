@@ -7,7 +7,35 @@ import (
 	"fmt"
 )
 
+var textColumnTypes = map[string]bool{
+	"UUID":     true,
+	"TEXT":     true,
+	"VARCHAR":  true,
+	"CHAR":     true,
+	"BPCHAR":   true,
+	"JSON":     true,
+	"JSONB":    true,
+	"NUMERIC":  true,
+	"DECIMAL":  true,
+	"ENUM":     true,
+	"NVARCHAR": true,
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
 func NewQueryFunc(db *sql.DB) QueryFunc {
+	return newQueryFunc(db)
+}
+
+// finds through the transaction see the rows it inserted before they are committed
+func NewTxQueryFunc(tx *sql.Tx) QueryFunc {
+	return newQueryFunc(tx)
+}
+
+func newQueryFunc(db queryer) QueryFunc {
 	return func(ctx context.Context, sqlStatement string, args ...any) (string, error) {
 		rows, err := db.QueryContext(ctx, sqlStatement, args...)
 		if err != nil {
@@ -73,3 +101,58 @@ func NewQueryFunc(db *sql.DB) QueryFunc {
 		return string(jsonData), nil
 	}
 }
+
+func NewQueryRowsFunc(db *sql.DB) QueryRowsFunc {
+	return newQueryRowsFunc(db)
+}
+
+func NewTxQueryRowsFunc(tx *sql.Tx) QueryRowsFunc {
+	return newQueryRowsFunc(tx)
+}
+
+func newQueryRowsFunc(db queryer) QueryRowsFunc {
+	return func(ctx context.Context, sqlStatement string, args ...any) ([]map[string]interface{}, error) {
+		rows, err := db.QueryContext(ctx, sqlStatement, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		columnTypes, err := rows.ColumnTypes()
+		if err != nil {
+			return nil, err
+		}
+
+		results := make([]map[string]interface{}, 0)
+		for rows.Next() {
+			values := make([]interface{}, len(columnTypes))
+			valuePointers := make([]interface{}, len(columnTypes))
+			for i := range columnTypes {
+				valuePointers[i] = &values[i]
+			}
+
+			if err := rows.Scan(valuePointers...); err != nil {
+				return nil, err
+			}
+
+			// Drivers hand back text like columns (uuid, json...) as bytes, only
+			// binary columns should stay []byte
+			row := make(map[string]interface{}, len(columnTypes))
+			for i, columnType := range columnTypes {
+				if b, ok := values[i].([]byte); ok && textColumnTypes[columnType.DatabaseTypeName()] {
+					row[columnType.Name()] = string(b)
+					continue
+				}
+				row[columnType.Name()] = values[i]
+			}
+
+			results = append(results, row)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		return results, nil
+	}
+}
@@ -0,0 +1,38 @@
+package factory
+
+import "context"
+
+type returningKey struct{}
+
+func withReturning(ctx context.Context, proto *Prototype) context.Context {
+	if proto == nil || len(proto.Returning) == 0 {
+		return ctx
+	}
+
+	columns := make([]string, len(proto.Returning))
+	for idx, key := range proto.Returning {
+		columns[idx] = proto.column(key)
+	}
+
+	return context.WithValue(ctx, returningKey{}, columns)
+}
+
+// withReturningColumn also returns column, so the returned rows can be told apart
+func withReturningColumn(ctx context.Context, column string) context.Context {
+	columns := returningColumns(ctx)
+	if len(columns) == 0 {
+		return ctx
+	}
+	for _, c := range columns {
+		if c == column {
+			return ctx
+		}
+	}
+
+	return context.WithValue(ctx, returningKey{}, append(append([]string(nil), columns...), column))
+}
+
+func returningColumns(ctx context.Context) []string {
+	columns, _ := ctx.Value(returningKey{}).([]string)
+	return columns
+}
@@ -0,0 +1,127 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+var savepointNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+type execer interface {
+	exec(ctx context.Context, sql string, args ...interface{}) error
+}
+
+type instanceState struct {
+	persisted         bool
+	created           bool
+	persistedContents map[string]interface{}
+	contents          map[string]interface{}
+}
+
+type savepoint map[*Instance]instanceState
+
+func (b *Builder) Savepoint(ctx context.Context, name string) error {
+	// parents that aren't registered, like detached ones, are saved with their children too
+	instances, err := b.saveOrder()
+	if err != nil {
+		return fmt.Errorf("could not create savepoint %s: %w", name, err)
+	}
+
+	if err := b.execSavepoint(ctx, "SAVEPOINT", name); err != nil {
+		return err
+	}
+
+	sp := make(savepoint, len(instances))
+	for _, instance := range instances {
+		sp[instance] = instanceState{
+			persisted:         instance.persisted,
+			created:           instance.created,
+			persistedContents: instance.persistedContents,
+			contents:          instance.contents,
+		}
+	}
+	b.savepoints[name] = sp
+
+	return nil
+}
+
+func (b *Builder) RollbackTo(ctx context.Context, name string) error {
+	sp, ok := b.savepoints[name]
+	if !ok {
+		return fmt.Errorf("could not roll back to %s: no savepoint found", name)
+	}
+
+	instances, err := b.saveOrder()
+	if err != nil {
+		return fmt.Errorf("could not roll back to %s: %w", name, err)
+	}
+
+	if err := b.execSavepoint(ctx, "ROLLBACK TO SAVEPOINT", name); err != nil {
+		return err
+	}
+
+	// instances saved after the savepoint go back to being unsaved, saving drops
+	// Default and Raw values from their contents so those come back as well
+	for _, instance := range instances {
+		state, ok := sp[instance]
+		instance.persisted = state.persisted
+		instance.created = state.created
+		instance.persistedContents = state.persistedContents
+		if ok {
+			instance.contents = state.contents
+		} else if instance.unsavedContents != nil {
+			instance.contents = instance.unsavedContents
+		}
+	}
+	b.invalidateFinds()
+
+	return nil
+}
+
+func (b *Builder) execSavepoint(ctx context.Context, statement, name string) error {
+	if !savepointNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid savepoint name %q", name)
+	}
+
+	e, ok := b.persister.(execer)
+	if !ok {
+		return fmt.Errorf("could not %s %s: persister does not support savepoints", statement, name)
+	}
+
+	if err := e.exec(ctx, statement+" "+name); err != nil {
+		return fmt.Errorf("could not %s %s: %w", statement, name, err)
+	}
+
+	return nil
+}
+
+const validateSavepoint = "factory_validate"
+
+func (b *Builder) Validate(ctx context.Context) error {
+	instances, err := b.saveOrder()
+	if err != nil {
+		return fmt.Errorf("could not validate: %w", err)
+	}
+
+	if err := b.Savepoint(ctx, validateSavepoint); err != nil {
+		return fmt.Errorf("could not validate: %w", err)
+	}
+	defer delete(b.savepoints, validateSavepoint)
+
+	b.validating = true
+	defer func() { b.validating = false }()
+	errs := b.saveInstances(ctx, instances, true)
+
+	if err := b.RollbackTo(ctx, validateSavepoint); err != nil {
+		return fmt.Errorf("could not validate: %w", err)
+	}
+	if err := b.execSavepoint(ctx, "RELEASE SAVEPOINT", validateSavepoint); err != nil {
+		return fmt.Errorf("could not validate: %w", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not validate: %w", errs[0])
+	}
+
+	return nil
+}
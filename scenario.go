@@ -0,0 +1,54 @@
+package factory
+
+import "fmt"
+
+type ScenarioBuilder struct {
+	builder *Builder
+	name    string
+	spec    GraphSpec
+	names   map[string]bool
+}
+
+func (b *Builder) Scenario(name string) *ScenarioBuilder {
+	return &ScenarioBuilder{builder: b, name: name, names: make(map[string]bool)}
+}
+
+func (s *ScenarioBuilder) Create(prototype, name string) *ScenarioBuilder {
+	if s.names[name] {
+		panic(fmt.Sprintf("could not build scenario %s: duplicate instance %s", s.name, name))
+	}
+
+	s.names[name] = true
+	s.spec.Nodes = append(s.spec.Nodes, GraphNode{Prototype: prototype, Name: name})
+	return s
+}
+
+// children can only belong to instances declared before them, so the scenario
+// reads top down like the graph it builds
+func (s *ScenarioBuilder) Child(parentName, prototype, name, fkColumn string) *ScenarioBuilder {
+	if !s.names[parentName] {
+		panic(fmt.Sprintf("could not build scenario %s: no instance %s declared before %s", s.name, parentName, name))
+	}
+
+	s.Create(prototype, name)
+	s.spec.Edges = append(s.spec.Edges, GraphEdge{Child: name, Parent: parentName, FKColumn: fkColumn})
+	return s
+}
+
+// With overrides an attribute of the instance declared last
+func (s *ScenarioBuilder) With(attr string, value interface{}) *ScenarioBuilder {
+	if len(s.spec.Nodes) == 0 {
+		panic(fmt.Sprintf("could not build scenario %s: With called before any instance was declared", s.name))
+	}
+
+	node := &s.spec.Nodes[len(s.spec.Nodes)-1]
+	if node.Overrides == nil {
+		node.Overrides = make(map[string]interface{})
+	}
+	node.Overrides[attr] = value
+	return s
+}
+
+func (s *ScenarioBuilder) Build() map[string]*Instance {
+	return s.builder.BuildGraph(s.spec)
+}
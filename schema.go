@@ -0,0 +1,189 @@
+package factory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+var quotedVarRegex = regexp.MustCompile(`"\{\{` + varNamePattern + `\}\}"`)
+
+type currentSchemaQuerier interface {
+	queryCurrentSchemaColumns(ctx context.Context, table string) ([]map[string]interface{}, error)
+}
+
+func (b *Builder) ValidateAgainstSchema(ctx context.Context) error {
+	names := make([]string, 0, len(b.prototypes))
+	for name, proto := range b.prototypes {
+		if proto.TableName != "" && !proto.BuildOnly {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	tables := map[string]map[string]string{}
+	var problems []string
+	for _, name := range names {
+		proto := b.prototypes[name]
+
+		columnTypes, ok := tables[proto.TableName]
+		if !ok {
+			var err error
+			columnTypes, err = b.tableColumnTypes(ctx, proto.TableName)
+			if err != nil {
+				return fmt.Errorf("could not validate schema of %s: %w", proto.TableName, err)
+			}
+			tables[proto.TableName] = columnTypes
+		}
+		if len(columnTypes) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: table %s does not exist", name, proto.TableName))
+			continue
+		}
+
+		// values set by setters are unknown until built, so they are not checked
+		outlineJSON := quotedVarRegex.ReplaceAllString(proto.Outline, "null")
+		decoder := json.NewDecoder(strings.NewReader(varReplacementRegex.ReplaceAllString(outlineJSON, "null")))
+		decoder.UseNumber()
+		var outline map[string]interface{}
+		if err := decoder.Decode(&outline); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: json error: %s", name, err.Error()))
+			continue
+		}
+
+		for _, key := range sortedColumns(outline) {
+			column := proto.column(key)
+			dataType, ok := columnTypes[column]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: column %s does not exist on %s", name, column, proto.TableName))
+				continue
+			}
+			if !typeCompatible(outline[key], dataType) {
+				problems = append(problems, fmt.Sprintf("%s: %s is %v but the column is %s", name, column, outline[key], dataType))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("outlines do not match the schema: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// the columns are read through the persister, so any sql database exposing
+// information_schema works; a table name with a schema is looked up in it
+func (b *Builder) tableColumnTypes(ctx context.Context, table string) (map[string]string, error) {
+	where := map[string]interface{}{"table_name": table}
+	if idx := strings.LastIndex(table, "."); idx >= 0 {
+		where = map[string]interface{}{"table_schema": table[:idx], "table_name": table[idx+1:]}
+	} else if b.schema != "" {
+		where["table_schema"] = b.schema
+	}
+
+	var (
+		rows []map[string]interface{}
+		err  error
+	)
+	// without a schema, tables of the same name in other schemas would be read as well.
+	// selecting the columns directly skips the DefaultFindOrderBy
+	q, current := b.persister.(currentSchemaQuerier)
+	if _, named := where["table_schema"]; current && !named {
+		rows, err = q.queryCurrentSchemaColumns(ctx, table)
+	} else if q, ok := b.persister.(columnQuerier); ok {
+		rows, err = q.queryColumns(ctx, "information_schema.columns", []string{"*"}, where)
+	} else {
+		rows, err = b.persister.Query(ctx, "information_schema.columns", where)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columnTypes := make(map[string]string, len(rows))
+	for _, row := range rows {
+		name, dataType := schemaField(row, "column_name"), schemaField(row, "data_type")
+		if name != "" {
+			columnTypes[name] = strings.ToLower(dataType)
+		}
+	}
+
+	return columnTypes, nil
+}
+
+// mysql names the information_schema columns in upper case and returns them as bytes
+func schemaField(row map[string]interface{}, field string) string {
+	for k, v := range row {
+		if !strings.EqualFold(k, field) {
+			continue
+		}
+		switch value := v.(type) {
+		case string:
+			return value
+		case []byte:
+			return string(value)
+		}
+	}
+
+	return ""
+}
+
+var numericTypes = map[string]bool{
+	"smallint": true, "integer": true, "bigint": true, "int": true, "tinyint": true, "mediumint": true,
+	"numeric": true, "decimal": true, "real": true, "double precision": true, "double": true, "float": true,
+}
+
+func typeCompatible(value interface{}, dataType string) bool {
+	isJSON := strings.Contains(dataType, "json")
+	isBool := strings.Contains(dataType, "bool")
+	isNumeric := numericTypes[dataType]
+	isTemporal := strings.Contains(dataType, "date") || strings.Contains(dataType, "time")
+
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return isJSON
+	case []interface{}:
+		return isJSON || dataType == "array"
+	case bool:
+		// mysql stores booleans as tinyint
+		return isBool || isJSON || dataType == "tinyint" || dataType == "bit"
+	case json.Number:
+		return !isBool && !isTemporal && dataType != "uuid"
+	case string:
+		if isNumeric {
+			_, err := strconv.ParseFloat(v, 64)
+			return err == nil
+		}
+		if isBool {
+			_, err := strconv.ParseBool(v)
+			return err == nil
+		}
+	}
+
+	return true
+}
+
+// postgres looks tables up in the first schema of the search_path, mysql in the
+// database of the connection
+func (p *SQLPersister) queryCurrentSchemaColumns(ctx context.Context, table string) ([]map[string]interface{}, error) {
+	currentSchema := "current_schema()"
+	if p.placeholderFormat != squirrel.Dollar {
+		currentSchema = "DATABASE()"
+	}
+
+	sql, args, err := squirrel.Select("*").From("information_schema.columns").
+		Where(squirrel.Eq{"table_name": table}).Where("table_schema = " + currentSchema).
+		PlaceholderFormat(p.placeholderFormat).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpSelect, sql)
+
+	return p.queryRows(ctx, sql, args...)
+}
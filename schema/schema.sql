@@ -1,5 +1,41 @@
 -- Create the "users" table
 CREATE TABLE users (
     id uuid PRIMARY KEY,
-    username VARCHAR(255) NOT NULL
+    username VARCHAR(255) NOT NULL,
+    profile JSONB,
+    status VARCHAR(255) NOT NULL DEFAULT 'active',
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
+
+-- Create the "orders" table
+CREATE TABLE orders (
+    id uuid PRIMARY KEY,
+    user_id uuid NOT NULL REFERENCES users(id),
+    total INTEGER NOT NULL DEFAULT 0
+);
+
+-- Create the "tag_kind" enum
+CREATE TYPE tag_kind AS ENUM ('topic', 'language');
+
+-- Create the "tags" table
+CREATE TABLE tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    color VARCHAR(255) DEFAULT 'grey',
+    addedBy VARCHAR(255),
+    kind tag_kind
+);
+
+-- Create the "members" table
+CREATE TABLE members (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    name VARCHAR(255),
+    UNIQUE (tenant_id, email)
+);
+
+-- Create the "active_users" view
+CREATE VIEW active_users AS
+    SELECT id, username FROM users WHERE status = 'active';
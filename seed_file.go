@@ -0,0 +1,150 @@
+package factory
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+func (b *Builder) WriteSeedFile(w io.Writer) error {
+	instances, err := b.saveOrder()
+	if err != nil {
+		return fmt.Errorf("could not write seed file: %w", err)
+	}
+
+	// the statements are built like the sql persister's, then the bound values are inlined.
+	// the placeholders are always ? for inlining, so the quotes follow the builder's database
+	seed := &SQLPersister{schema: b.schema, placeholderFormat: squirrel.Question, identifierQuote: `"`}
+	if p, ok := b.persister.(*SQLPersister); ok {
+		seed.identifierQuote = p.quote()
+	}
+	statements := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		if instance.saveAction() != saveInsert {
+			continue
+		}
+		for _, a := range instance.associations {
+			if _, ok := a.parent.contents[a.parent.idColumn()]; !ok {
+				return fmt.Errorf("could not write seed file: %s depends on %s which has no %s yet", instance.displayName(), a.parent.displayName(), a.parent.idColumn())
+			}
+		}
+
+		statement, err := instance.seedStatement(seed)
+		if err != nil {
+			return fmt.Errorf("could not write seed file: %s: %w", instance.displayName(), err)
+		}
+		statements = append(statements, statement)
+	}
+
+	if _, err := io.WriteString(w, "BEGIN;\n"); err != nil {
+		return fmt.Errorf("could not write seed file: %w", err)
+	}
+	for _, statement := range statements {
+		if _, err := io.WriteString(w, statement+";\n"); err != nil {
+			return fmt.Errorf("could not write seed file: %w", err)
+		}
+	}
+	if _, err := io.WriteString(w, "COMMIT;\n"); err != nil {
+		return fmt.Errorf("could not write seed file: %w", err)
+	}
+
+	return nil
+}
+
+func (i *Instance) seedStatement(seed *SQLPersister) (string, error) {
+	i.resolveAssociations()
+	row, err := i.insertContents()
+	if err != nil {
+		return "", err
+	}
+
+	ctx := withColumnCasts(withColumnOrder(context.Background(), i.prototype), i.prototype)
+	insertBuilder, err := seed.insertBuilder(ctx, i.tableName, row)
+	if err != nil {
+		return "", err
+	}
+	statement, args, err := insertBuilder.ToSql()
+	if err != nil {
+		return "", fmt.Errorf("could not build sql: %w", err)
+	}
+
+	return inlineArgs(statement, args)
+}
+
+// inlineArgs replaces the ? placeholders outside of quoted strings with the literals of args
+func inlineArgs(statement string, args []interface{}) (string, error) {
+	var (
+		out    strings.Builder
+		quoted bool
+		next   int
+	)
+	for _, r := range statement {
+		if r == '\'' {
+			quoted = !quoted
+		}
+		if r != '?' || quoted {
+			out.WriteRune(r)
+			continue
+		}
+		if next >= len(args) {
+			return "", fmt.Errorf("more placeholders than values in %s", statement)
+		}
+		literal, err := sqlLiteral(args[next])
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(literal)
+		next++
+	}
+	if next != len(args) {
+		return "", fmt.Errorf("more values than placeholders in %s", statement)
+	}
+
+	return out.String(), nil
+}
+
+func sqlLiteral(v interface{}) (string, error) {
+	if valuer, ok := v.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return "", err
+		}
+		v = value
+	}
+
+	switch value := v.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		if value {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", value), nil
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case string:
+		return quoteLiteral(value), nil
+	case []byte:
+		return quoteLiteral(string(value)), nil
+	case time.Time:
+		return quoteLiteral(value.Format(time.RFC3339Nano)), nil
+	case fmt.Stringer:
+		return quoteLiteral(value.String()), nil
+	default:
+		return "", fmt.Errorf("could not write %T as a sql literal", v)
+	}
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,69 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// \x60 is a backtick, which mysql quotes identifiers with
+var fromTableRegex = regexp.MustCompile(`(?i)\bFROM\s+(["\x60]?[a-zA-Z_][a-zA-Z0-9_]*["\x60]?(?:\.["\x60]?[a-zA-Z_][a-zA-Z0-9_]*["\x60]?)?)`)
+
+func (b *Builder) SelectBuilder(table string) squirrel.SelectBuilder {
+	p, ok := b.persister.(*SQLPersister)
+	if !ok {
+		panic(fmt.Sprintf("could not select from %s: persister does not build sql", table))
+	}
+
+	return squirrel.Select("*").From(p.table(table)).PlaceholderFormat(p.placeholderFormat)
+}
+
+func (b *Builder) FindBuilder(sb squirrel.SelectBuilder, instanceName ...string) ([]*Instance, error) {
+	p, ok := b.persister.(*SQLPersister)
+	if !ok {
+		return nil, fmt.Errorf("could not find: persister does not build sql")
+	}
+
+	sql, args, err := sb.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("could not find: could not build sql: %w", err)
+	}
+	sql = p.rewrite(OpSelect, sql)
+
+	table, ok := selectedTable(sql)
+	if !ok {
+		return nil, fmt.Errorf("could not find %s: no table selected from", sql)
+	}
+
+	rows, err := p.queryRows(context.Background(), sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not find %s: %w", sql, err)
+	}
+
+	instances, err := b.instancesFromRows(table, rows, instanceName...)
+	if err != nil {
+		return nil, fmt.Errorf("could not find %s: %w", sql, err)
+	}
+
+	b.instances = append(b.instances, instances...)
+	return instances, nil
+}
+
+// the rows are mapped to instances of the first table selected from, without
+// the schema it may be qualified with
+func selectedTable(sql string) (string, bool) {
+	match := fromTableRegex.FindStringSubmatch(sql)
+	if match == nil {
+		return "", false
+	}
+
+	table := strings.NewReplacer(`"`, "", "`", "").Replace(match[1])
+	if idx := strings.LastIndex(table, "."); idx >= 0 {
+		table = table[idx+1:]
+	}
+
+	return table, true
+}
@@ -0,0 +1,119 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+)
+
+const streamChunkSize = 500
+
+func (b *Builder) SaveStream(ctx context.Context, instances <-chan *Instance, progress func(done int)) error {
+	var (
+		tables   []string
+		pending  = make(map[string][]*Instance)
+		queued   = make(map[*Instance]bool)
+		flushing = make(map[string]bool)
+		received int
+		done     int
+		flush    func(table string) error
+	)
+	flush = func(table string) error {
+		chunk := pending[table]
+		if len(chunk) == 0 || flushing[table] {
+			return nil
+		}
+		flushing[table] = true
+		defer delete(flushing, table)
+
+		// the pending parents of the chunk are flushed with their own tables first,
+		// so children never reference rows that don't exist yet
+		ordered, err := dependencyOrder(chunk)
+		if err != nil {
+			return fmt.Errorf("could not save stream after %d instances: %w", done, err)
+		}
+		for _, instance := range ordered {
+			if queued[instance] && instance.tableName != table {
+				if err := flush(instance.tableName); err != nil {
+					return err
+				}
+			}
+		}
+
+		chunk = make([]*Instance, 0, len(pending[table]))
+		for _, instance := range ordered {
+			if queued[instance] && instance.tableName == table {
+				chunk = append(chunk, instance)
+				delete(queued, instance)
+			}
+		}
+		pending[table] = nil
+
+		if err := b.saveChunk(ctx, chunk); err != nil {
+			return fmt.Errorf("could not save stream after %d instances: %w", done, err)
+		}
+		done += len(chunk)
+		// tables are flushed separately, so only once nothing is pending are
+		// the saved instances the first ones received
+		if progress != nil && done == received {
+			progress(done)
+		}
+
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("could not save stream after %d instances: %w", done, ctx.Err())
+		case instance, ok := <-instances:
+			if !ok {
+				for _, table := range tables {
+					if err := flush(table); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			received++
+			if _, seen := pending[instance.tableName]; !seen {
+				tables = append(tables, instance.tableName)
+			}
+			pending[instance.tableName] = append(pending[instance.tableName], instance)
+			queued[instance] = true
+			if len(pending[instance.tableName]) >= streamChunkSize {
+				if err := flush(instance.tableName); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// saveChunk inserts the instances of one table with a single statement when the
+// persister supports it, whether or not BatchInserts is set
+func (b *Builder) saveChunk(ctx context.Context, instances []*Instance) error {
+	batcher, canBatch := b.persister.(BatchInserter)
+
+	var batch []*Instance
+	for _, instance := range instances {
+		switch {
+		case instance.saveAction() == saveSkip:
+			continue
+		case canBatch && instance.batchable():
+			batch = append(batch, instance)
+		default:
+			if err := instance.persist(ctx, b.persister); err != nil {
+				return fmt.Errorf("error saving %s into %s: %w", instance.displayName(), instance.tableName, err)
+			}
+		}
+	}
+
+	if len(batch) > 0 {
+		if errs := insertBatches(ctx, batcher, batch); len(errs) > 0 {
+			return errs[0]
+		}
+	}
+
+	return nil
+}
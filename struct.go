@@ -0,0 +1,123 @@
+package factory
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type structField struct {
+	column string
+	index  []int
+}
+
+func (b *Builder) BuildFromStruct(prototypeName string, v interface{}, instanceName ...string) *Instance {
+	overrides, err := b.structToMap(v)
+	if err != nil {
+		panic(fmt.Sprintf("could not build instance of %s: %s", prototypeName, err.Error()))
+	}
+
+	instance, err := b.build(prototypeName, buildOptions{overrides: overrides}, instanceName...)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return instance
+}
+
+func (i *Instance) ToStruct(dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("could not scan %s: destination must be a pointer to a struct, got %T", i.name, dst)
+	}
+
+	target := rv.Elem()
+	for _, field := range i.baseBuilder.structFields(target.Type()) {
+		value, ok := i.contents[field.column]
+		if !ok {
+			continue
+		}
+
+		// round tripping through json converts between the representations
+		// found rows come back with, e.g. float64 to int or string to time.Time
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("could not scan %s into %s: %w", field.column, target.Type(), err)
+		}
+		fieldValue := target.FieldByIndex(field.index)
+		if err := json.Unmarshal(encoded, fieldValue.Addr().Interface()); err != nil {
+			return fmt.Errorf("could not scan %s into %s: %w", field.column, target.Type(), err)
+		}
+	}
+
+	return nil
+}
+
+func (b *Builder) structToMap(v interface{}) (map[string]interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct, got %T", v)
+	}
+
+	row := make(map[string]interface{})
+	for _, field := range b.structFields(rv.Type()) {
+		value := rv.FieldByIndex(field.index)
+		if value.IsZero() {
+			continue
+		}
+		row[field.column] = value.Interface()
+	}
+
+	return row, nil
+}
+
+func (b *Builder) structFields(t reflect.Type) []structField {
+	var fields []structField
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		if !field.IsExported() {
+			continue
+		}
+
+		column, ok := b.structColumn(field)
+		if !ok {
+			continue
+		}
+
+		if field.Anonymous && column == "" && field.Type.Kind() == reflect.Struct {
+			for _, nested := range b.structFields(field.Type) {
+				nested.index = append([]int{idx}, nested.index...)
+				fields = append(fields, nested)
+			}
+			continue
+		}
+
+		if column == "" {
+			column = snakeCase(field.Name)
+		}
+		fields = append(fields, structField{column: column, index: []int{idx}})
+	}
+
+	return fields
+}
+
+func (b *Builder) structColumn(field reflect.StructField) (string, bool) {
+	for _, key := range []string{b.structTag, "json"} {
+		tag, ok := field.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			return "", false
+		}
+		return name, true
+	}
+
+	return "", true
+}
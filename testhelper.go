@@ -0,0 +1,17 @@
+package factory
+
+import (
+	"context"
+	"testing"
+)
+
+func NewForTest(tb testing.TB, config *BuilderConfig) *Builder {
+	b := NewBuilder(config)
+	tb.Cleanup(func() {
+		if err := b.Cleanup(context.Background()); err != nil {
+			tb.Errorf("could not clean up factory: %s", err.Error())
+		}
+	})
+
+	return b
+}
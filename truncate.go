@@ -0,0 +1,90 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type truncater interface {
+	truncate(ctx context.Context, tables []string) error
+}
+
+func (b *Builder) Truncate(ctx context.Context, tables ...string) error {
+	if len(tables) == 0 {
+		tables = b.prototypeTables()
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	for _, table := range tables {
+		if !identifierRegex.MatchString(table) {
+			return fmt.Errorf("could not truncate: invalid table name %q", table)
+		}
+	}
+
+	t, ok := b.persister.(truncater)
+	if !ok {
+		return fmt.Errorf("could not truncate %s: persister does not support truncating", strings.Join(tables, ", "))
+	}
+	if err := t.truncate(ctx, tables); err != nil {
+		return fmt.Errorf("could not truncate %s: %w", strings.Join(tables, ", "), err)
+	}
+
+	truncated := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		truncated[table] = true
+	}
+	instances := make([]*Instance, 0, len(b.instances))
+	for _, instance := range b.instances {
+		if !truncated[instance.tableName] {
+			instances = append(instances, instance)
+		}
+	}
+	b.instances = instances
+	b.invalidateFinds(tables...)
+
+	return nil
+}
+
+func (b *Builder) prototypeTables() []string {
+	seen := make(map[string]bool, len(b.prototypes))
+	tables := make([]string, 0, len(b.prototypes))
+	for _, proto := range b.prototypes {
+		if proto.TableName != "" && !seen[proto.TableName] {
+			seen[proto.TableName] = true
+			tables = append(tables, proto.TableName)
+		}
+	}
+	sort.Strings(tables)
+
+	return tables
+}
+
+// postgres can truncate all tables in one statement and reset their sequences,
+// mysql truncates one table at a time and always resets auto increments but
+// refuses to truncate referenced tables unless foreign key checks are off.
+// foreign_key_checks only holds for the session, so the statements are sent in
+// one exec instead of leaving a pooled connection with the checks off
+func (p *SQLPersister) truncate(ctx context.Context, tables []string) error {
+	if p.placeholderFormat == squirrel.Dollar {
+		qualified := make([]string, len(tables))
+		for idx, table := range tables {
+			qualified[idx] = p.table(table)
+		}
+		return p.exec(ctx, "TRUNCATE "+strings.Join(qualified, ", ")+" RESTART IDENTITY CASCADE")
+	}
+
+	statements := make([]string, 0, len(tables)+2)
+	statements = append(statements, "SET FOREIGN_KEY_CHECKS = 0")
+	for _, table := range tables {
+		statements = append(statements, "TRUNCATE TABLE "+p.table(table))
+	}
+	statements = append(statements, "SET FOREIGN_KEY_CHECKS = 1")
+
+	return p.exec(ctx, strings.Join(statements, "; "))
+}
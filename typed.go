@@ -0,0 +1,44 @@
+package factory
+
+import (
+	"fmt"
+	"reflect"
+)
+
+type TypedFactory[T any] struct {
+	builder       *Builder
+	prototypeName string
+}
+
+func Typed[T any](b *Builder, prototypeName string) *TypedFactory[T] {
+	if _, ok := b.prototypes[prototypeName]; !ok {
+		panic(fmt.Sprintf("could not create typed factory for %s: no prototype found", prototypeName))
+	}
+	if t := reflect.TypeOf((*T)(nil)).Elem(); t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("could not create typed factory for %s: %s is not a struct", prototypeName, t))
+	}
+
+	return &TypedFactory[T]{builder: b, prototypeName: prototypeName}
+}
+
+func (f *TypedFactory[T]) Build(name string, overrides T) *Instance {
+	if name == "" {
+		return f.builder.BuildFromStruct(f.prototypeName, overrides)
+	}
+
+	return f.builder.BuildFromStruct(f.prototypeName, overrides, name)
+}
+
+func (f *TypedFactory[T]) Find(query string, instanceName ...string) []T {
+	table := f.builder.prototypes[f.prototypeName].TableName
+	instances := f.builder.Find(table, query, instanceName...)
+
+	results := make([]T, len(instances))
+	for idx, instance := range instances {
+		if err := instance.ToStruct(&results[idx]); err != nil {
+			panic(err.Error())
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,97 @@
+package factory
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type upsertKey struct{}
+
+type upsert struct {
+	conflictColumns []string
+	keepColumns     map[string]bool
+	doNothing       bool
+}
+
+func withUpsert(ctx context.Context, proto *Prototype) context.Context {
+	if proto == nil || (len(proto.ConflictColumns) == 0 && !proto.ConflictDoNothing) {
+		return ctx
+	}
+
+	u := upsert{keepColumns: make(map[string]bool, len(proto.ConflictColumns)+1), doNothing: proto.ConflictDoNothing}
+	for _, key := range proto.ConflictColumns {
+		column := proto.column(key)
+		u.conflictColumns = append(u.conflictColumns, column)
+		u.keepColumns[column] = true
+	}
+	// the existing row keeps its primary key, which is returned to the instance
+	if proto.PrimaryKey != "" {
+		u.keepColumns[proto.column(proto.PrimaryKey)] = true
+	}
+
+	return context.WithValue(ctx, upsertKey{}, u)
+}
+
+// upsertSuffix turns an insert of columns into an update of the conflicting row.
+// postgres targets the conflict columns, mysql updates on any duplicate key
+func upsertSuffix(ctx context.Context, columns []string, format squirrel.PlaceholderFormat) (string, bool) {
+	u, ok := ctx.Value(upsertKey{}).(upsert)
+	if !ok {
+		return "", false
+	}
+
+	postgres := format == squirrel.Dollar
+	if u.doNothing {
+		return doNothingSuffix(u, columns, postgres), true
+	}
+
+	var set []string
+	for _, column := range columns {
+		if u.keepColumns[column] {
+			continue
+		}
+		if postgres {
+			set = append(set, column+" = EXCLUDED."+column)
+		} else {
+			set = append(set, column+" = VALUES("+column+")")
+		}
+	}
+	if len(set) == 0 {
+		// still update the row, so it is returned like an inserted one
+		set = append(set, u.conflictColumns[0]+" = "+u.conflictColumns[0])
+	}
+
+	if postgres {
+		return "ON CONFLICT (" + strings.Join(u.conflictColumns, ", ") + ") DO UPDATE SET " + strings.Join(set, ", "), true
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(set, ", "), true
+}
+
+// mysql has no DO NOTHING, but setting a column to itself leaves the row
+// unchanged and reports no affected rows, like postgres does
+func doNothingSuffix(u upsert, columns []string, postgres bool) string {
+	if postgres {
+		if len(u.conflictColumns) == 0 {
+			return "ON CONFLICT DO NOTHING"
+		}
+		return "ON CONFLICT (" + strings.Join(u.conflictColumns, ", ") + ") DO NOTHING"
+	}
+
+	var column string
+	switch {
+	case len(u.conflictColumns) > 0:
+		column = u.conflictColumns[0]
+	case len(columns) > 0:
+		column = columns[0]
+	default:
+		return ""
+	}
+	return "ON DUPLICATE KEY UPDATE " + column + " = " + column
+}
+
+func ignoresConflicts(ctx context.Context) bool {
+	u, ok := ctx.Value(upsertKey{}).(upsert)
+	return ok && u.doNothing
+}